@@ -12,7 +12,6 @@ import (
 	"time"
 
 	tea "charm.land/bubbletea/v2"
-	"golang.org/x/crypto/ssh"
 )
 
 // backupFile represents a discovered backup on disk.
@@ -201,40 +200,7 @@ func performBackup(e *Entry, backupDir string) tea.Cmd {
 
 		ch <- backupProgressMsg{message: "Running pg_dump in container..."}
 
-		docker := dockerCmd(client)
-
-		// Run pg_dump inside the Docker container.
-		cmd := fmt.Sprintf("%s exec %s pg_dump -U %s --no-owner --no-acl %s",
-			docker,
-			shellQuote(e.Container),
-			shellQuote(e.DBUser),
-			shellQuote(e.Database),
-		)
-
-		session, err := client.NewSession()
-		if err != nil {
-			outFile.Close()
-			os.Remove(outPath)
-			ch <- backupProgressMsg{err: fmt.Errorf("ssh session: %w", err), done: true}
-			return
-		}
-
-		stdout, err := session.StdoutPipe()
-		if err != nil {
-			session.Close()
-			outFile.Close()
-			os.Remove(outPath)
-			ch <- backupProgressMsg{err: fmt.Errorf("stdout pipe: %w", err), done: true}
-			return
-		}
-
-		if err := session.Start(cmd); err != nil {
-			session.Close()
-			outFile.Close()
-			os.Remove(outPath)
-			ch <- backupProgressMsg{err: fmt.Errorf("start pg_dump: %w", err), done: true}
-			return
-		}
+		engine := newContainerEngine(client, e.runtime)
 
 		// Progress ticker goroutine.
 		doneCh := make(chan struct{})
@@ -255,24 +221,18 @@ func performBackup(e *Entry, backupDir string) tea.Cmd {
 			}
 		}()
 
-		// Copy stdout → gzip → counting writer → file.
-		_, copyErr := io.Copy(gzw, stdout)
-		waitErr := session.Wait()
+		// Run pg_dump inside the Docker container, copying its output
+		// → gzip → counting writer → file.
+		dumpErr := engine.exec(e.dockerName(), []string{"pg_dump", "-U", e.DBUser, "--no-owner", "--no-acl", e.Database}, nil, gzw, 0)
 		close(doneCh)
-		session.Close()
 
 		gzErr := gzw.Close()
 		fileErr := outFile.Close()
 
 		// Check for errors in priority order.
-		if waitErr != nil {
+		if dumpErr != nil {
 			os.Remove(outPath)
-			ch <- backupProgressMsg{err: fmt.Errorf("pg_dump failed: %w", waitErr), done: true}
-			return
-		}
-		if copyErr != nil {
-			os.Remove(outPath)
-			ch <- backupProgressMsg{err: fmt.Errorf("stream: %w", copyErr), done: true}
+			ch <- backupProgressMsg{err: fmt.Errorf("pg_dump failed: %w", dumpErr), done: true}
 			return
 		}
 		if gzErr != nil {
@@ -344,7 +304,10 @@ func performRestore(e *Entry, backupPath string) tea.Cmd {
 		// Phase 1: Drop and recreate public schema.
 		ch <- restoreProgressMsg{phase: "drop", message: "Dropping public schema..."}
 
-		docker := dockerCmd(client)
+		engine := newContainerEngine(client, e.runtime)
+		psql := func(args ...string) []string {
+			return append([]string{"psql", "-U", e.DBUser, "-d", e.Database}, args...)
+		}
 
 		// Drop non-default extensions first — DROP SCHEMA CASCADE removes
 		// their objects but leaves the pg_extension record, which causes
@@ -354,25 +317,10 @@ func performRestore(e *Entry, backupPath string) tea.Cmd {
 FOR ext IN SELECT extname FROM pg_extension WHERE extname != 'plpgsql' LOOP
 EXECUTE 'DROP EXTENSION IF EXISTS ' || quote_ident(ext.extname) || ' CASCADE';
 END LOOP; END $$;`
-		dropExtCmd := fmt.Sprintf("%s exec %s psql -U %s -d %s -c %s",
-			docker,
-			shellQuote(e.Container),
-			shellQuote(e.DBUser),
-			shellQuote(e.Database),
-			shellQuote(dropExtSQL),
-		)
-		_ = runSSHCommandSimple(client, dropExtCmd) // best-effort
+		_ = engine.exec(e.dockerName(), psql("-c", dropExtSQL), nil, io.Discard, e.runtime.commandTimeout()) // best-effort
 
 		dropSQL := "DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public; GRANT ALL ON SCHEMA public TO public;"
-		dropCmd := fmt.Sprintf("%s exec %s psql -U %s -d %s -c %s",
-			docker,
-			shellQuote(e.Container),
-			shellQuote(e.DBUser),
-			shellQuote(e.Database),
-			shellQuote(dropSQL),
-		)
-
-		if err := runSSHCommandSimple(client, dropCmd); err != nil {
+		if err := engine.exec(e.dockerName(), psql("-c", dropSQL), nil, io.Discard, e.runtime.commandTimeout()); err != nil {
 			ch <- restoreProgressMsg{err: fmt.Errorf("drop schema: %w", err), done: true}
 			return
 		}
@@ -403,33 +351,6 @@ END LOOP; END $$;`
 		}
 		defer gzr.Close()
 
-		// Run psql inside the Docker container, piping stdin.
-		restoreCmd := fmt.Sprintf("%s exec -i %s psql -U %s -d %s --quiet -v ON_ERROR_STOP=0",
-			docker,
-			shellQuote(e.Container),
-			shellQuote(e.DBUser),
-			shellQuote(e.Database),
-		)
-
-		session, err := client.NewSession()
-		if err != nil {
-			ch <- restoreProgressMsg{err: fmt.Errorf("ssh session: %w", err), done: true}
-			return
-		}
-
-		stdinPipe, err := session.StdinPipe()
-		if err != nil {
-			session.Close()
-			ch <- restoreProgressMsg{err: fmt.Errorf("stdin pipe: %w", err), done: true}
-			return
-		}
-
-		if err := session.Start(restoreCmd); err != nil {
-			session.Close()
-			ch <- restoreProgressMsg{err: fmt.Errorf("start psql: %w", err), done: true}
-			return
-		}
-
 		// Progress ticker.
 		doneCh := make(chan struct{})
 		go func() {
@@ -455,20 +376,13 @@ END LOOP; END $$;`
 			}
 		}()
 
-		// Pipe gunzipped data into SSH stdin (→ docker exec → psql).
-		_, copyErr := io.Copy(stdinPipe, gzr)
-		stdinPipe.Close()
-		waitErr := session.Wait()
+		// Pipe gunzipped data into psql inside the Docker container.
+		restoreErr := engine.exec(e.dockerName(), psql("--quiet", "-v", "ON_ERROR_STOP=0"), gzr, io.Discard, 0)
 		close(doneCh)
-		session.Close()
 
-		if copyErr != nil {
-			ch <- restoreProgressMsg{err: fmt.Errorf("stream: %w", copyErr), done: true}
-			return
-		}
-		if waitErr != nil {
+		if restoreErr != nil {
 			// psql may exit non-zero on non-fatal SQL warnings; only fail on real errors.
-			ch <- restoreProgressMsg{err: fmt.Errorf("psql: %w", waitErr), done: true}
+			ch <- restoreProgressMsg{err: fmt.Errorf("psql: %w", restoreErr), done: true}
 			return
 		}
 
@@ -478,14 +392,7 @@ END LOOP; END $$;`
 			`SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '%s' AND pid != pg_backend_pid()`,
 			e.Database,
 		)
-		terminateCmd := fmt.Sprintf("%s exec %s psql -U %s -d %s -c %s",
-			docker,
-			shellQuote(e.Container),
-			shellQuote(e.DBUser),
-			shellQuote(e.Database),
-			shellQuote(terminateSQL),
-		)
-		_ = runSSHCommandSimple(client, terminateCmd)
+		_ = engine.exec(e.dockerName(), psql("-c", terminateSQL), nil, io.Discard, e.runtime.commandTimeout())
 
 		ch <- restoreProgressMsg{
 			bytesRead: totalSize,
@@ -499,36 +406,6 @@ END LOOP; END $$;`
 	return nextRestoreProgress(ch)
 }
 
-// runSSHCommandSimple runs a command on an SSH client and returns any error.
-func runSSHCommandSimple(client *ssh.Client, cmd string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("session: %w", err)
-	}
-	defer session.Close()
-
-	type result struct {
-		err error
-	}
-	ch := make(chan result, 1)
-	go func() {
-		out, err := session.CombinedOutput(cmd)
-		if err != nil {
-			ch <- result{fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))}
-		} else {
-			ch <- result{nil}
-		}
-	}()
-
-	select {
-	case r := <-ch:
-		return r.err
-	case <-time.After(30 * time.Second):
-		session.Close()
-		return fmt.Errorf("command timed out after 30s")
-	}
-}
-
 // countingWriter wraps a writer and counts bytes written.
 type countingWriter struct {
 	w     io.Writer
@@ -586,3 +463,101 @@ func formatBytes(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
 }
+
+// runBackup implements `drillbit backup <host>/<container>`. Progress goes
+// to stderr; the path of the finished backup is printed on stdout.
+func runBackup(app *cliApp, args []string) int {
+	fs := app.flagSet("backup")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected <host>/<container>")
+	}
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
+	}
+
+	e, err := findTargetEntry(app.cfg, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	cmd := performBackup(e, app.cfg.BackupDirectory(app.configPath))
+	for cmd != nil {
+		msg := cmd().(backupProgressMsg)
+		if msg.err != nil {
+			fmt.Fprintf(app.stderr, "\nError: %v\n", msg.err)
+			return exitError
+		}
+		if msg.message != "" {
+			fmt.Fprintf(app.stderr, "\r\033[K%s", msg.message)
+		}
+		if msg.done {
+			fmt.Fprintln(app.stderr)
+			if msg.filePath != "" {
+				fmt.Fprintln(app.stdout, msg.filePath)
+			}
+			return exitOK
+		}
+		cmd = msg.next
+	}
+	return exitOK
+}
+
+// runRestore implements `drillbit restore <host>/<container> <backup-file>`.
+// It asks for confirmation on stdin unless --yes is given.
+func runRestore(app *cliApp, args []string) int {
+	fs := app.flagSet("restore")
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		return usageError(fs, "expected <host>/<container> and a backup file")
+	}
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
+	}
+	backupPath := fs.Arg(1)
+	if _, err := os.Stat(backupPath); err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	e, err := findTargetEntry(app.cfg, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if !*yes {
+		fmt.Fprintf(app.stderr, "Restore %s into %s/%s/%s?\n", filepath.Base(backupPath), e.Host, e.Container, e.Database)
+		fmt.Fprint(app.stderr, "This DROPS the public schema first. Type 'yes' to continue: ")
+		var answer string
+		fmt.Fscanln(os.Stdin, &answer)
+		if answer != "yes" {
+			fmt.Fprintln(app.stderr, "Cancelled.")
+			return exitError
+		}
+	}
+
+	cmd := performRestore(e, backupPath)
+	for cmd != nil {
+		msg := cmd().(restoreProgressMsg)
+		if msg.err != nil {
+			fmt.Fprintf(app.stderr, "\nError: %v\n", msg.err)
+			return exitError
+		}
+		if msg.message != "" {
+			fmt.Fprintf(app.stderr, "\r\033[K%s", msg.message)
+		}
+		if msg.done {
+			fmt.Fprintln(app.stderr)
+			return exitOK
+		}
+		cmd = msg.next
+	}
+	return exitOK
+}
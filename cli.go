@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Exit codes shared by every subcommand.
+const (
+	exitOK    = 0 // success
+	exitError = 1 // the command ran and failed
+	exitUsage = 2 // bad flags or arguments
+)
+
+// cliCommand describes one drillbit subcommand.
+type cliCommand struct {
+	name    string
+	args    string // argument synopsis shown in usage, e.g. "<host>/<container>"
+	summary string
+	hidden  bool // omitted from help and completions
+	run     func(app *cliApp, args []string) int
+}
+
+// cliApp holds global state shared by subcommands.
+type cliApp struct {
+	configPath string
+	profile    string  // named profile in use; "" for the default config
+	cfg        *Config // set by loadConfig
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// commands is the subcommand table. Populated in init to avoid an
+// initialization cycle (help and completion read the table).
+var commands []*cliCommand
+
+func init() {
+	commands = []*cliCommand{
+		{name: "tui", summary: "Interactive tunnel manager (default)", run: runTUI},
+		{name: "up", summary: "Hold autoconnect tunnels open without the TUI", run: runUp},
+		{name: "list", summary: "Print discovered databases and exit", run: runList},
+		{name: "exec", args: "<host>/<container> -- <cmd> [args...]", summary: "Run cmd with PG* env vars pointing at a tunnel", run: runExec},
+		{name: "ctl", args: "<list|status|connect|disconnect|connstr> [host/container]", summary: "Query or drive a running drillbit TUI", run: runCtl},
+		{name: "backup", args: "<host>/<container>", summary: "Back up a database with pg_dump", run: runBackup},
+		{name: "restore", args: "<host>/<container> <backup-file>", summary: "Restore a database from a backup", run: runRestore},
+		{name: "doctor", args: "[host...]", summary: "Diagnose SSH, Docker and discovery for each host", run: runDoctor},
+		{name: "update", summary: "Update drillbit to the latest release", run: runUpdate},
+		{name: "vault", args: "<init|migrate|list>", summary: "Manage the encrypted password vault", run: runVault},
+		{name: "config", args: "<path|edit|validate>", summary: "Inspect, edit or validate the config file", run: runConfigCmd},
+		{name: "profile", args: "<list|create> [name]", summary: "List or create named profiles", run: runProfile},
+		{name: "completion", args: "<bash|zsh|fish>", summary: "Print a shell completion script", run: runCompletion},
+		{name: "version", summary: "Show version", run: runVersion},
+		{name: "help", args: "[command]", summary: "Show help for a command", run: runHelp},
+		{name: "__complete", hidden: true, run: runComplete},
+	}
+}
+
+// findCommand returns the command with the given name, or nil.
+func findCommand(name string) *cliCommand {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// runCLI parses global flags, picks a subcommand and runs it. Returns the
+// process exit code.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	app := &cliApp{configPath: DefaultConfigPath(), stdout: stdout, stderr: stderr}
+
+	global := flag.NewFlagSet("drillbit", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { printUsage(stderr) }
+	global.StringVar(&app.configPath, "config", app.configPath, "config file")
+	global.StringVar(&app.configPath, "c", app.configPath, "config file (shorthand)")
+	global.StringVar(&app.profile, "profile", os.Getenv("DRILLBIT_PROFILE"), "named profile")
+	var showVersion, editMode bool
+	global.BoolVar(&showVersion, "version", false, "show version")
+	global.BoolVar(&showVersion, "v", false, "show version (shorthand)")
+	global.BoolVar(&editMode, "edit", false, "open config in $EDITOR")
+	global.BoolVar(&editMode, "e", false, "open config in $EDITOR (shorthand)")
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	// An explicit --config wins over $DRILLBIT_PROFILE, but not over
+	// --profile.
+	set := make(map[string]bool)
+	global.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["config"] || set["c"] {
+		if set["profile"] {
+			fmt.Fprintf(stderr, "Error: --config and --profile can't be used together\n")
+			return exitUsage
+		}
+		app.profile = ""
+	}
+	if app.profile == defaultProfile {
+		app.profile = ""
+	}
+	if app.profile != "" {
+		if err := checkProfileName(app.profile); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		app.configPath = profileConfigPath(app.profile)
+	}
+
+	switch {
+	case showVersion:
+		return runVersion(app, nil)
+	case editMode:
+		return runConfigCmd(app, []string{"edit"})
+	}
+
+	name, rest := "tui", global.Args()
+	if len(rest) > 0 {
+		name, rest = rest[0], rest[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "Error: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	return cmd.run(app, rest)
+}
+
+// loadConfig loads app.cfg, reporting any error on stderr. Commands call
+// it after parsing their arguments so usage errors win over config errors.
+func (app *cliApp) loadConfig() bool {
+	cfg, err := LoadConfig(app.configPath)
+	if err != nil {
+		if app.profile != "" && errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(app.stderr, "Error: profile %q does not exist (create it with: drillbit profile create %s)\n", app.profile, app.profile)
+			return false
+		}
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return false
+	}
+	for _, w := range cfg.warnings {
+		fmt.Fprintf(app.stderr, "Warning: %s\n", w)
+	}
+	app.cfg = cfg
+	return true
+}
+
+// flagSet returns a FlagSet for a subcommand whose usage text includes
+// the command synopsis and summary.
+func (app *cliApp) flagSet(name string) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(app.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(app.stderr, "Usage: drillbit %s", name)
+		if hasFlags(fs) {
+			fmt.Fprint(app.stderr, " [options]")
+		}
+		if cmd != nil && cmd.args != "" {
+			fmt.Fprint(app.stderr, " "+cmd.args)
+		}
+		fmt.Fprintln(app.stderr)
+		if cmd != nil && cmd.summary != "" {
+			fmt.Fprintf(app.stderr, "\n%s\n", cmd.summary)
+		}
+		if hasFlags(fs) {
+			fmt.Fprintln(app.stderr, "\nOptions:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses subcommand flags. When ok is false the caller should
+// return code immediately (help was shown, or the flags were invalid).
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// usageError reports a bad invocation and prints the command's usage.
+func usageError(fs *flag.FlagSet, format string, a ...any) int {
+	fmt.Fprintf(fs.Output(), "Error: "+format+"\n\n", a...)
+	fs.Usage()
+	return exitUsage
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	return n > 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: drillbit [global options] [command] [args...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		if !c.hidden {
+			fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global options:")
+	fmt.Fprintln(w, "  -c, --config <path>   Config file (default: ~/.config/drillbit/config.yaml)")
+	fmt.Fprintln(w, "      --profile <name>  Use a named profile (default: $DRILLBIT_PROFILE)")
+	fmt.Fprintln(w, "  -e, --edit            Open config in $EDITOR (same as: drillbit config edit)")
+	fmt.Fprintln(w, "  -v, --version         Show version")
+	fmt.Fprintln(w, "  -h, --help            Show this help")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'drillbit help <command>' for command options.")
+}
+
+func runVersion(app *cliApp, args []string) int {
+	fmt.Fprintf(app.stdout, "drillbit %s (commit: %s, built: %s)\n", version, commit, buildDate)
+	return exitOK
+}
+
+func runHelp(app *cliApp, args []string) int {
+	if len(args) == 0 {
+		printUsage(app.stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.hidden {
+		fmt.Fprintf(app.stderr, "Error: unknown command %q\n", args[0])
+		return exitUsage
+	}
+	return cmd.run(app, []string{"-h"})
+}
+
+// --- Shell completion ---
+
+func runCompletion(app *cliApp, args []string) int {
+	fs := app.flagSet("completion")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected one shell name")
+	}
+
+	var names []string
+	for _, c := range commands {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Fprintf(app.stdout, bashCompletion, strings.Join(names, " "))
+	case "zsh":
+		var described []string
+		for _, c := range commands {
+			if !c.hidden {
+				described = append(described, fmt.Sprintf("'%s:%s'", c.name, strings.ReplaceAll(c.summary, "'", "")))
+			}
+		}
+		fmt.Fprintf(app.stdout, zshCompletion, strings.Join(described, "\n    "))
+	case "fish":
+		fmt.Fprint(app.stdout, "complete -c drillbit -f\n")
+		for _, c := range commands {
+			if !c.hidden {
+				fmt.Fprintf(app.stdout, "complete -c drillbit -n __fish_use_subcommand -a %s -d %q\n", c.name, c.summary)
+			}
+		}
+		fmt.Fprint(app.stdout, fishCompletion)
+	default:
+		return usageError(fs, "unsupported shell %q (want bash, zsh or fish)", fs.Arg(0))
+	}
+	return exitOK
+}
+
+// runComplete is the hidden helper the completion scripts call to fetch
+// dynamic candidates. "targets" prints host/container pairs from the last
+// cached discovery; "hosts" prints configured host names; "profiles"
+// prints profile names.
+func runComplete(app *cliApp, args []string) int {
+	if len(args) == 0 {
+		return exitUsage
+	}
+	var out []string
+	switch args[0] {
+	case "targets":
+		if cache, err := loadDiscoveryCache(app.configPath); err == nil {
+			out = cache.Targets
+		}
+	case "hosts":
+		if cfg, err := LoadConfig(app.configPath); err == nil {
+			for _, h := range cfg.Hosts {
+				out = append(out, h.Name)
+			}
+		}
+	case "profiles":
+		out = append([]string{defaultProfile}, listProfiles()...)
+	}
+	sort.Strings(out)
+	for _, s := range out {
+		fmt.Fprintln(app.stdout, s)
+	}
+	return exitOK
+}
+
+// The completion scripts pass the --config or --profile given before the
+// command on to __complete, so candidates come from the same config.
+
+const bashCompletion = `# bash completion for drillbit
+_drillbit() {
+    local cur cmd i
+    local -a global=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    cmd=""
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -c|-config|--config|-profile|--profile)
+                ((i + 1 < COMP_CWORD)) && global+=("${COMP_WORDS[i]}" "${COMP_WORDS[i+1]/#\~/$HOME}")
+                ((i++)) ;;
+            -config=*|--config=*|-profile=*|--profile=*) global+=("${COMP_WORDS[i]/=\~/=$HOME}") ;;
+            -*) ;;
+            *) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+
+    if [[ "${COMP_WORDS[COMP_CWORD-1]}" == --profile || "${COMP_WORDS[COMP_CWORD-1]}" == -profile ]]; then
+        COMPREPLY=($(compgen -W "$(_drillbit_complete profiles)" -- "$cur"))
+        return
+    fi
+    if [[ -z "$cmd" ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    case "$cmd" in
+        exec|backup)
+            COMPREPLY=($(compgen -W "$(_drillbit_complete targets)" -- "$cur")) ;;
+        ctl)
+            if [[ $((COMP_CWORD - i)) -eq 1 ]]; then
+                COMPREPLY=($(compgen -W "list status connect disconnect connstr" -- "$cur"))
+            else
+                COMPREPLY=($(compgen -W "$(_drillbit_complete targets)" -- "$cur"))
+            fi ;;
+        restore)
+            if [[ $((COMP_CWORD - i)) -eq 1 ]]; then
+                COMPREPLY=($(compgen -W "$(_drillbit_complete targets)" -- "$cur"))
+            else
+                COMPREPLY=($(compgen -f -- "$cur"))
+            fi ;;
+        doctor) COMPREPLY=($(compgen -W "$(_drillbit_complete hosts)" -- "$cur")) ;;
+        completion) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+        config) COMPREPLY=($(compgen -W "path edit validate" -- "$cur")) ;;
+        vault) COMPREPLY=($(compgen -W "init migrate list" -- "$cur")) ;;
+        profile) COMPREPLY=($(compgen -W "list create" -- "$cur")) ;;
+    esac
+}
+# _drillbit_complete prints candidates, using the global options _drillbit found.
+_drillbit_complete() {
+    drillbit "${global[@]}" __complete "$1" 2>/dev/null
+}
+complete -F _drillbit drillbit
+`
+
+const zshCompletion = `#compdef drillbit
+_drillbit() {
+    local -a cmds global
+    cmds=(
+    %s
+    )
+    local i=2 cmd=""
+    while (( i < CURRENT )); do
+        case $words[i] in
+            -c|-config|--config|-profile|--profile)
+                (( i + 1 < CURRENT )) && global+=($words[i] ${words[i+1]/#\~/$HOME})
+                (( i++ )) ;;
+            -config=*|--config=*|-profile=*|--profile=*) global+=(${words[i]/=\~/=$HOME}) ;;
+            -*) ;;
+            *) cmd=$words[i]; break ;;
+        esac
+        (( i++ ))
+    done
+
+    if [[ $words[CURRENT-1] == (-profile|--profile) ]]; then
+        compadd -- ${(f)"$(drillbit $global __complete profiles 2>/dev/null)"}
+        return
+    fi
+    if [[ -z $cmd ]]; then
+        _describe 'command' cmds
+        return
+    fi
+    case $cmd in
+        exec|backup) compadd -- ${(f)"$(drillbit $global __complete targets 2>/dev/null)"} ;;
+        ctl)
+            if (( CURRENT - i == 1 )); then
+                compadd list status connect disconnect connstr
+            else
+                compadd -- ${(f)"$(drillbit $global __complete targets 2>/dev/null)"}
+            fi ;;
+        restore)
+            if (( CURRENT - i == 1 )); then
+                compadd -- ${(f)"$(drillbit $global __complete targets 2>/dev/null)"}
+            else
+                _files
+            fi ;;
+        doctor) compadd -- ${(f)"$(drillbit $global __complete hosts 2>/dev/null)"} ;;
+        completion) compadd bash zsh fish ;;
+        config) compadd path edit validate ;;
+        vault) compadd init migrate list ;;
+        profile) compadd list create ;;
+    esac
+}
+compdef _drillbit drillbit
+`
+
+const fishCompletion = `# __drillbit_complete prints candidates, passing on the --config or
+# --profile given before the command.
+function __drillbit_complete
+    set -l global
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    while set -q tokens[1]
+        switch $tokens[1]
+            case -c -config --config -profile --profile
+                set -a global $tokens[1] (string replace -r '^~' -- $HOME $tokens[2])
+                set -e tokens[1]
+            case '-config=*' '--config=*' '-profile=*' '--profile=*'
+                set -a global (string replace -r '=~' -- =$HOME $tokens[1])
+            case '-*'
+            case '*'
+                break
+        end
+        set -e tokens[1]
+    end
+    drillbit $global __complete $argv 2>/dev/null
+end
+complete -c drillbit -n '__fish_seen_subcommand_from exec backup restore ctl' -a '(__drillbit_complete targets)'
+complete -c drillbit -n '__fish_seen_subcommand_from ctl' -a 'list status connect disconnect connstr'
+complete -c drillbit -n '__fish_seen_subcommand_from restore' -F
+complete -c drillbit -n '__fish_seen_subcommand_from doctor' -a '(__drillbit_complete hosts)'
+complete -c drillbit -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+complete -c drillbit -n '__fish_seen_subcommand_from config' -a 'path edit validate'
+complete -c drillbit -n '__fish_seen_subcommand_from vault' -a 'init migrate list'
+complete -c drillbit -n '__fish_seen_subcommand_from profile' -a 'list create'
+complete -c drillbit -l profile -x -a '(__drillbit_complete profiles)'
+`
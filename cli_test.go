@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCLITest(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = runCLI(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRunCLI(t *testing.T) {
+	t.Run("version", func(t *testing.T) {
+		for _, args := range [][]string{{"--version"}, {"-v"}, {"version"}} {
+			code, out, _ := runCLITest(args...)
+			if code != exitOK || !strings.HasPrefix(out, "drillbit ") {
+				t.Errorf("%v: code=%d out=%q", args, code, out)
+			}
+		}
+	})
+
+	t.Run("global help", func(t *testing.T) {
+		code, _, errOut := runCLITest("--help")
+		if code != exitOK || !strings.Contains(errOut, "Commands:") {
+			t.Errorf("code=%d stderr=%q", code, errOut)
+		}
+	})
+
+	t.Run("command help", func(t *testing.T) {
+		code, _, errOut := runCLITest("help", "list")
+		if code != exitOK || !strings.Contains(errOut, "Usage: drillbit list") || !strings.Contains(errOut, "-format") {
+			t.Errorf("code=%d stderr=%q", code, errOut)
+		}
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		code, _, errOut := runCLITest("frobnicate")
+		if code != exitUsage || !strings.Contains(errOut, `unknown command "frobnicate"`) {
+			t.Errorf("code=%d stderr=%q", code, errOut)
+		}
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		if code, _, _ := runCLITest("list", "--nope"); code != exitUsage {
+			t.Errorf("code=%d, want %d", code, exitUsage)
+		}
+	})
+
+	t.Run("usage errors win over config errors", func(t *testing.T) {
+		code, _, errOut := runCLITest("-c", "/nonexistent/config.yaml", "backup")
+		if code != exitUsage || strings.Contains(errOut, "reading config") {
+			t.Errorf("code=%d stderr=%q", code, errOut)
+		}
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		code, _, errOut := runCLITest("-c", "/nonexistent/config.yaml", "list")
+		if code != exitError || !strings.Contains(errOut, "reading config") {
+			t.Errorf("code=%d stderr=%q", code, errOut)
+		}
+	})
+
+	t.Run("config path", func(t *testing.T) {
+		code, out, _ := runCLITest("--config", "/tmp/custom.yaml", "config", "path")
+		if code != exitOK || out != "/tmp/custom.yaml\n" {
+			t.Errorf("code=%d out=%q", code, out)
+		}
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	os.WriteFile(path, []byte("hosts:\n  - name: server1\n    databases:\n      - container: db1\n"), 0o600)
+	code, out, _ := runCLITest("-c", path, "config", "validate")
+	if code != exitOK || !strings.Contains(out, "OK (1 hosts, 1 database overrides)") {
+		t.Errorf("valid: code=%d out=%q", code, out)
+	}
+
+	os.WriteFile(path, []byte("hosts:\n  - name: server1\n    envv: prod\n"), 0o600)
+	code, _, errOut := runCLITest("-c", path, "config", "validate")
+	if code != exitError || !strings.Contains(errOut, path+`:3:5: unknown field "envv"`) {
+		t.Errorf("invalid: code=%d stderr=%q", code, errOut)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			code, out, _ := runCLITest("completion", shell)
+			if code != exitOK {
+				t.Fatalf("code=%d", code)
+			}
+			// Candidates come from the config given on the command line.
+			for _, want := range []string{"list", "backup", "__complete", "targets", "--config", "--profile"} {
+				if !strings.Contains(out, want) {
+					t.Errorf("%s completion missing %q", shell, want)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown shell", func(t *testing.T) {
+		if code, _, _ := runCLITest("completion", "tcsh"); code != exitUsage {
+			t.Errorf("code=%d, want %d", code, exitUsage)
+		}
+	})
+}
+
+func TestCompleteTargets(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+
+	// No cache yet: no candidates, but no failure either.
+	code, out, _ := runCLITest("-c", configPath, "__complete", "targets")
+	if code != exitOK || out != "" {
+		t.Errorf("code=%d out=%q", code, out)
+	}
+
+	saveDiscoveryCache(configPath, []Entry{
+		{Host: "server2", Container: "db1"},
+		{Host: "server1", Container: "db2"},
+	})
+	_, out, _ = runCLITest("-c", configPath, "__complete", "targets")
+	if out != "server1/db2\nserver2/db1\n" {
+		t.Errorf("targets = %q", out)
+	}
+}
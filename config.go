@@ -1,18 +1,55 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"go.yaml.in/yaml/v3"
 )
 
 // Config is the top-level configuration for DrillBit.
 type Config struct {
-	Hosts     []HostConfig `yaml:"hosts"`
+	Version   int          `yaml:"version,omitempty"`      // schema version; see migrate.go
+	Include   []string     `yaml:"include,omitempty"`      // extra fragment files (globs)
+	Imports   []HostImport `yaml:"import_hosts,omitempty"` // generated hosts; see import.go
+	Hosts     []HostConfig `yaml:"hosts,omitempty"`
 	BackupDir string       `yaml:"backup_dir,omitempty"`
+	PortRange string       `yaml:"port_range,omitempty"` // e.g. "10000-65535"
+	// EnvPortRanges gives an env label its own port range, so the port
+	// alone tells which environment a connection goes to.
+	EnvPortRanges map[string]string `yaml:"env_port_ranges,omitempty"`
+	// Discovery rules for every host, tried after a host's own; see rules.go.
+	Discovery []DiscoveryRule `yaml:"discovery,omitempty"`
+
+	// Set by LoadConfig when fragments or imported hosts were merged in;
+	// see include.go.
+	shared *Config // merged fragments and imported hosts, without the user's own file
+	own    *Config // the user's own file as loaded
+
+	// Problems LoadConfig found that don't stop the config from loading,
+	// such as Docker contexts import_hosts can't import.
+	warnings []string
+
+	// configStamp of the files as loaded or last saved; SaveConfig
+	// refuses to write over a change made since. Empty for a config that
+	// wasn't loaded from disk.
+	stamp string
+}
+
+// localPorts returns the range local ports are assigned from for an env:
+// its env_port_ranges entry, or else port_range.
+func (cfg *Config) localPorts(env string) portRange {
+	if r, err := parsePortRange(cfg.EnvPortRanges[env]); err == nil {
+		return r
+	}
+	if r, err := parsePortRange(cfg.PortRange); err == nil {
+		return r
+	}
+	return defaultPortRange
 }
 
 // BackupDirectory returns the configured backup directory, defaulting to
@@ -35,27 +72,115 @@ func expandTildePath(p string) string {
 
 // HostConfig represents a single SSH host with optional database overrides.
 type HostConfig struct {
-	Name      string              `yaml:"name"`
-	User      string              `yaml:"user,omitempty"`
-	Env       string              `yaml:"env,omitempty"`
-	Databases []DatabaseOverride  `yaml:"databases,omitempty"`
+	Name        string `yaml:"name"`
+	User        string `yaml:"user,omitempty"`
+	Env         string `yaml:"env,omitempty"`
+	HostRuntime `yaml:",inline"`
+	Databases   []DatabaseOverride `yaml:"databases,omitempty"`
+	Discovery   []DiscoveryRule    `yaml:"discovery,omitempty"` // tried before the config's rules
+
+	globalDiscovery []DiscoveryRule // the config's rules, set by LoadConfig
+}
+
+// Sudo policies for HostRuntime.Sudo.
+const (
+	sudoAuto   = "auto" // use sudo only if the plain CLI can't reach the daemon (default)
+	sudoAlways = "always"
+	sudoNever  = "never"
+)
+
+// Defaults for unset HostRuntime durations.
+const (
+	defaultCommandTimeout   = 30 * time.Second
+	defaultDiscoveryTimeout = 30 * time.Second
+	defaultKeepAlive        = 30 * time.Second
+)
+
+// HostRuntime holds how drillbit runs commands on a host. Zero values
+// mean the defaults. It is used for discovery, tunnels, backup and restore
+// alike.
+type HostRuntime struct {
+	Docker           string        `yaml:"docker,omitempty"`            // container CLI, e.g. "podman" or "docker -H unix:///run/user/1000/docker.sock"
+	DockerSocket     string        `yaml:"docker_socket,omitempty"`     // Engine API socket on the host, used instead of the CLI
+	Sudo             string        `yaml:"sudo,omitempty"`              // auto, always or never
+	CommandTimeout   time.Duration `yaml:"command_timeout,omitempty"`   // per remote command
+	DiscoveryTimeout time.Duration `yaml:"discovery_timeout,omitempty"` // for the container scan
+	KeepAlive        time.Duration `yaml:"keepalive,omitempty"`         // SSH keepalive interval
+}
+
+// containerCLI returns the container CLI command, without sudo.
+func (r HostRuntime) containerCLI() string {
+	return firstNonEmpty(r.Docker, "docker")
+}
+
+func (r HostRuntime) commandTimeout() time.Duration {
+	return firstNonEmpty(r.CommandTimeout, defaultCommandTimeout)
+}
+
+func (r HostRuntime) discoveryTimeout() time.Duration {
+	return firstNonEmpty(r.DiscoveryTimeout, defaultDiscoveryTimeout)
+}
+
+func (r HostRuntime) keepAlive() time.Duration {
+	return firstNonEmpty(r.KeepAlive, defaultKeepAlive)
 }
 
 // DatabaseOverride allows per-database configuration.
 type DatabaseOverride struct {
+	Container string
+	Auto      bool
+	User      string
+	Password  string
+	Database  string
+	Port      uint16 // pinned local port; 0 lets drillbit assign one
+
+	autoSet        bool // auto was written explicitly, so "auto: false" is kept
+	passwordShared bool // Password comes from a shared fragment; see resolveSecret
+}
+
+// databaseOverrideYAML is the on-disk form of DatabaseOverride. Auto is a
+// pointer so an explicit "auto: false" can override a fragment's "auto: true".
+type databaseOverrideYAML struct {
 	Container string `yaml:"container"`
-	Auto      bool   `yaml:"auto,omitempty"`
+	Auto      *bool  `yaml:"auto,omitempty"`
 	User      string `yaml:"user,omitempty"`
 	Password  string `yaml:"password,omitempty"`
 	Database  string `yaml:"database,omitempty"`
+	Port      uint16 `yaml:"port,omitempty"`
+}
+
+func (d *DatabaseOverride) UnmarshalYAML(n *yaml.Node) error {
+	var w databaseOverrideYAML
+	if err := n.Decode(&w); err != nil {
+		return err
+	}
+	*d = DatabaseOverride{Container: w.Container, User: w.User, Password: w.Password, Database: w.Database, Port: w.Port}
+	if w.Auto != nil {
+		d.Auto, d.autoSet = *w.Auto, true
+	}
+	return nil
+}
+
+func (d DatabaseOverride) MarshalYAML() (any, error) {
+	w := databaseOverrideYAML{Container: d.Container, User: d.User, Password: d.Password, Database: d.Database, Port: d.Port}
+	if d.Auto || d.autoSet {
+		w.Auto = &d.Auto
+	}
+	return w, nil
 }
 
-// SSHHost returns "user@name" if user is set, otherwise just "name".
+// SSHHost returns "user@name" if user is set, otherwise just "name". A
+// name may carry a user of its own, as in "deploy@server1"; the user
+// field replaces it.
 func (hc HostConfig) SSHHost() string {
-	if hc.User != "" {
-		return hc.User + "@" + hc.Name
+	if hc.User == "" {
+		return hc.Name
+	}
+	host := hc.Name
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
 	}
-	return hc.Name
+	return hc.User + "@" + host
 }
 
 // GetOverride returns the override for a specific container, or nil if none exists.
@@ -98,37 +223,101 @@ func DefaultConfigPath() string {
 	return filepath.Join(home, ".config", "drillbit", "config.yaml")
 }
 
-// LoadConfig reads and parses the YAML config file.
+// LoadConfig reads, parses and validates the YAML config file, then merges
+// in any fragments it includes (see loadFragments) and the hosts generated
+// by its import_hosts rules (see importHosts). Validation problems are
+// returned together as ConfigErrors.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config %s: %w", path, err)
 	}
+	own, err := parseConfig(path, data, false)
+	if err != nil {
+		return nil, err
+	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing YAML config: %w", err)
+	shared, err := loadFragments(path, own.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	// Imported hosts are the lowest layer, below fragments.
+	imports := own.Imports
+	if shared != nil {
+		imports = append(append([]HostImport(nil), shared.Imports...), own.Imports...)
+	}
+	imported, warnings, err := importHosts(imports)
+	if err != nil {
+		return nil, err
+	}
+	if len(imported) > 0 {
+		base := &Config{Hosts: imported}
+		if shared != nil {
+			shared = mergeConfigs(base, shared)
+		} else {
+			shared = base
+		}
+	}
+
+	cfg := own
+	if shared != nil {
+		cfg = mergeConfigs(shared, own)
+		cfg.shared, cfg.own = shared, own
 	}
 
 	if len(cfg.Hosts) == 0 {
 		return nil, fmt.Errorf("config has no hosts defined")
 	}
-
-	return &cfg, nil
+	for i := range cfg.Hosts {
+		cfg.Hosts[i].globalDiscovery = cfg.Discovery
+	}
+	cfg.stamp = configStamp(path, cfg)
+	cfg.warnings = warnings
+	return cfg, nil
 }
 
-// SaveConfig writes the config back to disk as YAML.
-func SaveConfig(cfg *Config, path string) error {
-	data, err := yaml.Marshal(cfg)
-	if err != nil {
-		return fmt.Errorf("marshaling config: %w", err)
-	}
+// errConfigChanged is returned by SaveConfig when the config file or one
+// of its fragments changed on disk since cfg was loaded or saved.
+var errConfigChanged = errors.New("config changed on disk")
 
+// SaveConfig writes the config back to disk. Only the user's own layer is
+// written: values that come from shared fragments stay out of it. If the
+// file already exists, only changed keys are rewritten so comments and
+// layout survive. A file from an older schema version is backed up and
+// upgraded first (see upgradeConfigFile). The write is atomic and made
+// under a lock on path + ".lock", so processes sharing the file take
+// turns. A config from LoadConfig is only written if the files are as it
+// was loaded or last saved; otherwise nothing is written and
+// errConfigChanged is returned.
+func SaveConfig(cfg *Config, path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("creating config directory: %w", err)
 	}
-	return os.WriteFile(path, data, 0o600)
+	return withFileLock(path, func() error {
+		if cfg.stamp != "" && configStamp(path, cfg) != cfg.stamp {
+			return errConfigChanged
+		}
+		orig, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("reading config: %w", err)
+		}
+		if orig, err = upgradeConfigFile(path, orig); err != nil {
+			return err
+		}
+		layer := *cfg.personalLayer()
+		layer.Version = configVersion
+		data, err := mergeYAML(orig, &layer)
+		if err != nil {
+			return fmt.Errorf("marshaling config: %w", err)
+		}
+		if err := writeFileAtomic(path, data, 0o600); err != nil {
+			return err
+		}
+		cfg.stamp = configStamp(path, cfg)
+		return nil
+	})
 }
 
 // ScaffoldConfig creates a documented example config file.
@@ -140,6 +329,9 @@ func ScaffoldConfig(path string) error {
 
 	content := `# DrillBit Configuration
 # backup_dir: ~/drillbit-backups  # optional, defaults to config dir + /backups
+# port_range: 10000-65535          # optional, local ports are assigned from here
+
+version: 1
 
 hosts:
   - name: prod-server-1
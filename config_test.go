@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHostConfigSSHHost(t *testing.T) {
@@ -15,6 +18,8 @@ func TestHostConfigSSHHost(t *testing.T) {
 		{"with user", HostConfig{Name: "server1", User: "deploy"}, "deploy@server1"},
 		{"without user", HostConfig{Name: "server1"}, "server1"},
 		{"empty user", HostConfig{Name: "server1", User: ""}, "server1"},
+		{"user in the name", HostConfig{Name: "admin@server1"}, "admin@server1"},
+		{"user field replaces the name's", HostConfig{Name: "admin@server1", User: "deploy"}, "deploy@server1"},
 	}
 
 	for _, tt := range tests {
@@ -190,6 +195,106 @@ func TestSaveConfig(t *testing.T) {
 	}
 }
 
+func TestSaveConfigStamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, path, "version: 1\nhosts:\n  - name: server1\n")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving twice from the same process is not a conflict.
+	for range 2 {
+		cfg.BackupDir = cfg.BackupDir + "x"
+		if err := SaveConfig(cfg, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edited := "version: 1\nhosts:\n  - name: server1\n  - name: server2 # added by hand\n"
+	writeTestFile(t, path, edited)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if err := SaveConfig(cfg, path); !errors.Is(err, errConfigChanged) {
+		t.Errorf("err = %v, want errConfigChanged", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != edited {
+		t.Errorf("the edit was overwritten:\n%s", data)
+	}
+}
+
+func TestHostRuntime(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	orig := `version: 1
+hosts:
+  - name: server1
+    docker: podman
+    sudo: never
+    command_timeout: 1m30s
+    keepalive: 15s
+  - name: server2
+`
+	os.WriteFile(path, []byte(orig), 0o600)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := cfg.Hosts[0].HostRuntime
+	if rt.containerCLI() != "podman" || rt.Sudo != sudoNever || rt.commandTimeout() != 90*time.Second ||
+		rt.discoveryTimeout() != defaultDiscoveryTimeout || rt.keepAlive() != 15*time.Second {
+		t.Errorf("server1 runtime = %+v", rt)
+	}
+	def := cfg.Hosts[1].HostRuntime
+	if def.containerCLI() != "docker" || def.commandTimeout() != defaultCommandTimeout || def.keepAlive() != defaultKeepAlive {
+		t.Errorf("server2 runtime = %+v, want defaults", def)
+	}
+
+	// Durations are written back in the same form.
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != orig {
+		t.Errorf("round trip changed the file:\n%s", data)
+	}
+}
+
+func TestHostRuntimeDurationSpelling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	orig := `version: 1
+hosts:
+  - name: server1
+    command_timeout: 2m
+    discovery_timeout: 90s
+    keepalive: 1h
+`
+	writeTestFile(t, path, orig)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt := cfg.Hosts[0].HostRuntime; rt.commandTimeout() != 2*time.Minute || rt.discoveryTimeout() != 90*time.Second {
+		t.Fatalf("runtime = %+v", rt)
+	}
+
+	// Unchanged durations keep how they were written, not "2m0s" or "1m30s".
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != orig {
+		t.Errorf("round trip changed the file:\n%s", data)
+	}
+
+	// A changed one is written out.
+	cfg.Hosts[0].CommandTimeout = 3 * time.Minute
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "command_timeout: 3m0s\n") || !strings.Contains(string(data), "discovery_timeout: 90s\n") {
+		t.Errorf("after changing command_timeout:\n%s", data)
+	}
+}
+
 func TestScaffoldConfig(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "drillbit", "config.yaml")
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// The control socket lets other shells query and drive a running TUI.
+// The protocol is newline-delimited JSON: one ctlRequest per line in,
+// one ctlResponse per line out. Requests are routed into the Bubbletea
+// program as ctlRequestMsg so Model stays the single source of truth.
+
+const ctlReplyTimeout = 5 * time.Second
+
+// ctlRequest is a single control command.
+type ctlRequest struct {
+	Cmd    string `json:"cmd"`              // list, status, connect, disconnect, connstr
+	Target string `json:"target,omitempty"` // host/container
+}
+
+// ctlEntry is an Entry as reported over the control socket.
+type ctlEntry struct {
+	listRecord
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ctlResponse is the reply to a ctlRequest.
+type ctlResponse struct {
+	OK      bool       `json:"ok"`
+	Error   string     `json:"error,omitempty"`
+	Entries []ctlEntry `json:"entries,omitempty"`
+	Entry   *ctlEntry  `json:"entry,omitempty"`
+	ConnStr string     `json:"connstr,omitempty"`
+}
+
+// ctlRequestMsg carries a control request into Update. The handler must
+// send exactly one response on reply (which is buffered).
+type ctlRequestMsg struct {
+	req   ctlRequest
+	reply chan<- ctlResponse
+}
+
+// controlSocketPath returns the control socket location for a config file.
+func controlSocketPath(configPath string) string {
+	return configStatePath(configPath, "drillbit.sock")
+}
+
+// controlServer accepts control connections on a Unix-domain socket.
+type controlServer struct {
+	ln   net.Listener
+	path string
+	send func(tea.Msg)
+}
+
+// startControlServer listens on path and forwards requests to send
+// (normally tea.Program.Send). A stale socket from a crashed process is
+// replaced; a live one means another instance owns it and is an error.
+func startControlServer(path string, send func(tea.Msg)) (*controlServer, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("control socket %s is in use by another instance", path)
+	}
+	os.Remove(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating socket directory: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+	os.Chmod(path, 0o600)
+
+	s := &controlServer{ln: ln, path: path, send: send}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops accepting connections and removes the socket file.
+func (s *controlServer) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers requests on one connection until the client hangs up.
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req ctlRequest
+		var resp ctlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = ctlResponse{Error: fmt.Sprintf("bad request: %v", err)}
+		} else {
+			resp = s.dispatch(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch hands a request to the Bubbletea program and waits for the reply.
+func (s *controlServer) dispatch(req ctlRequest) ctlResponse {
+	reply := make(chan ctlResponse, 1)
+	go s.send(ctlRequestMsg{req: req, reply: reply})
+	select {
+	case resp := <-reply:
+		return resp
+	case <-time.After(ctlReplyTimeout):
+		return ctlResponse{Error: "timed out waiting for drillbit"}
+	}
+}
+
+// --- Model side ---
+
+// handleControl answers a control request from the current Model state,
+// returning any command needed to carry it out (e.g. a tunnel Connect).
+func (m *Model) handleControl(req ctlRequest) (ctlResponse, tea.Cmd) {
+	if req.Cmd == "list" {
+		resp := ctlResponse{OK: true, Entries: make([]ctlEntry, 0, len(m.entries))}
+		for i := range m.entries {
+			resp.Entries = append(resp.Entries, newCtlEntry(&m.entries[i]))
+		}
+		return resp, nil
+	}
+
+	switch req.Cmd {
+	case "status", "connect", "disconnect", "connstr":
+	default:
+		return ctlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)}, nil
+	}
+
+	host, container, ok := strings.Cut(req.Target, "/")
+	if !ok {
+		return ctlResponse{Error: fmt.Sprintf("target %q must be <host>/<container>", req.Target)}, nil
+	}
+	e := m.findEntry(host + ":" + container)
+	if e == nil {
+		if m.discovering {
+			return ctlResponse{Error: "discovery in progress, try again shortly"}, nil
+		}
+		return ctlResponse{Error: fmt.Sprintf("%s not found", req.Target)}, nil
+	}
+
+	var cmd tea.Cmd
+	switch req.Cmd {
+	case "connect":
+		if e.Status == StatusReady || e.Status == StatusError {
+			e.Status = StatusConnecting
+			e.Error = ""
+			cmd = m.tunnels.Connect(e)
+		}
+	case "disconnect":
+		if e.Status == StatusConnected {
+			e.Status = StatusReady
+			cmd = m.tunnels.Disconnect(e)
+		}
+	case "connstr":
+		if e.Status != StatusConnected {
+			return ctlResponse{Error: fmt.Sprintf("%s is not connected", req.Target)}, nil
+		}
+		entry := newCtlEntry(e)
+		return ctlResponse{OK: true, Entry: &entry, ConnStr: connString(e)}, nil
+	}
+
+	entry := newCtlEntry(e)
+	return ctlResponse{OK: true, Entry: &entry}, cmd
+}
+
+func newCtlEntry(e *Entry) ctlEntry {
+	return ctlEntry{
+		listRecord: listRecord{
+			Env:       e.Env,
+			Host:      e.Host,
+			Container: e.Container,
+			Image:     e.Image,
+			User:      e.DBUser,
+			Database:  e.Database,
+			Port:      e.LocalPort,
+		},
+		Status: e.Status.String(),
+		Error:  e.Error,
+	}
+}
+
+// --- Client side (`drillbit ctl ...`) ---
+
+// ctlCall sends one request to the control socket and returns the reply.
+func ctlCall(path string, req ctlRequest) (ctlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return ctlResponse{}, fmt.Errorf("no running drillbit found at %s", path)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ctlReplyTimeout + time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return ctlResponse{}, fmt.Errorf("sending request: %w", err)
+	}
+	var resp ctlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return ctlResponse{}, fmt.Errorf("reading response: %w", err)
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// runCtl implements `drillbit ctl <list|status|connect|disconnect|connstr> [host/container]`.
+func runCtl(app *cliApp, args []string) int {
+	fs := app.flagSet("ctl")
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	wait := fs.Duration("wait", 30*time.Second, "how long connect waits for the tunnel (0 to return immediately)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if fs.NArg() == 0 {
+		return usageError(fs, "missing ctl command")
+	}
+	req := ctlRequest{Cmd: fs.Arg(0), Target: fs.Arg(1)}
+	if req.Cmd != "list" && req.Target == "" {
+		return usageError(fs, "%s requires <host>/<container>", req.Cmd)
+	}
+
+	path := controlSocketPath(app.configPath)
+	resp, err := ctlCall(path, req)
+	if err == nil && req.Cmd == "connect" && *wait > 0 {
+		resp, err = ctlWaitConnected(path, req.Target, *wait)
+	}
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(app.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(resp)
+		return exitOK
+	}
+
+	switch req.Cmd {
+	case "list":
+		tw := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ENV\tHOST\tCONTAINER\tPORT\tSTATUS")
+		for _, e := range resp.Entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", e.Env, e.Host, e.Container, e.Port, e.Status)
+		}
+		tw.Flush()
+	case "connstr":
+		fmt.Fprintln(app.stdout, resp.ConnStr)
+	default:
+		e := resp.Entry
+		fmt.Fprintf(app.stdout, "%s/%s %s localhost:%d\n", e.Host, e.Container, e.Status, e.Port)
+	}
+	return exitOK
+}
+
+// ctlWaitConnected polls status until the target is connected, fails, or
+// the timeout passes.
+func ctlWaitConnected(path, target string, timeout time.Duration) (ctlResponse, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := ctlCall(path, ctlRequest{Cmd: "status", Target: target})
+		if err != nil {
+			return resp, err
+		}
+		switch resp.Entry.Status {
+		case StatusConnected.String():
+			return resp, nil
+		case StatusError.String():
+			return resp, fmt.Errorf("%s: %s", target, resp.Entry.Error)
+		}
+		if time.Now().After(deadline) {
+			return resp, fmt.Errorf("%s: still %s after %s", target, resp.Entry.Status, timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
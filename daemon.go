@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// daemonPollInterval is how often the headless daemon checks tunnel state.
+const daemonPollInterval = 5 * time.Second
+
+// daemonRediscoverInterval is how often the daemon looks again at hosts
+// that had no databases, e.g. because they were down when it started.
+const daemonRediscoverInterval = time.Minute
+
+// runUp is the headless counterpart of the TUI: it discovers all hosts,
+// connects every autoconnect entry and holds the tunnels until SIGINT or
+// SIGTERM. Hosts without databases are discovered again now and then, so
+// the daemon also waits for targets that aren't there yet. Progress and
+// tunnel state changes are logged to stderr. Returns the process exit
+// code.
+func runUp(app *cliApp, args []string) int {
+	fs := app.flagSet("up")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
+	}
+	lock, other, err := acquireInstance(app.configPath, "up")
+	switch {
+	case errors.Is(err, errInstanceRunning):
+		fmt.Fprintf(app.stderr, "Error: drillbit is already running with this config (%s)\n", other)
+		return exitError
+	case err != nil:
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	defer lock.Unlock()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := newDaemon(app.cfg, app.configPath, log.New(app.stderr, "drillbit: ", log.LstdFlags), ctx.Done())
+	defer d.tm.DisconnectAll()
+
+	entries, errs := collectDiscovery(app.cfg.Hosts, func(l logEntry) {
+		d.logger.Println(formatLogEntry(l))
+	})
+	d.addEntries(entries, errs)
+	if len(d.targets) == 0 {
+		d.logger.Println("no autoconnect databases found yet — waiting for them to appear")
+	}
+	d.syncTunnels()
+
+	ticker := time.NewTicker(daemonPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Println("shutting down, closing tunnels")
+			return exitOK
+		case r := <-d.connected:
+			d.connectDone(r)
+		case r := <-d.discovered:
+			d.discovering = false
+			d.addEntries(r.entries, r.errs)
+			d.syncTunnels()
+		case <-ticker.C:
+			d.syncTunnels()
+			d.rediscover()
+		}
+	}
+}
+
+// daemon is the state of `drillbit up`. It is only touched by runUp's
+// loop; connects and rediscoveries run in goroutines that report back on
+// the connected and discovered channels.
+type daemon struct {
+	cfg        *Config
+	configPath string
+	logger     *log.Logger
+	tm         *TunnelManager
+	ports      *portState
+	done       <-chan struct{} // closed on shutdown
+
+	entries  []Entry           // copies of everything discovered, for port assignment
+	hosts    map[string]bool   // hosts with entries
+	hostErrs map[string]string // last error logged per host
+	targets  []*Entry          // autoconnect entries
+	pending  map[string]bool   // tunnelKeys with a connect in flight
+
+	discovering  bool
+	lastDiscover time.Time
+	connected    chan connectResult
+	discovered   chan discoverResult
+}
+
+// connectResult is the outcome of a connect started by the daemon.
+type connectResult struct {
+	e   *Entry
+	ip  string // container IP the tunnel used
+	msg tea.Msg
+}
+
+// discoverResult is the outcome of a rediscovery.
+type discoverResult struct {
+	entries []Entry
+	errs    []hostError
+}
+
+func newDaemon(cfg *Config, configPath string, logger *log.Logger, done <-chan struct{}) *daemon {
+	return &daemon{
+		cfg:          cfg,
+		configPath:   configPath,
+		logger:       logger,
+		tm:           NewTunnelManager(),
+		ports:        loadPortState(cfg, configPath),
+		done:         done,
+		hosts:        make(map[string]bool),
+		hostErrs:     make(map[string]string),
+		pending:      make(map[string]bool),
+		lastDiscover: time.Now(),
+		connected:    make(chan connectResult),
+		discovered:   make(chan discoverResult),
+	}
+}
+
+// addEntries takes in the entries of a discovery: they get local ports
+// without moving those of entries found earlier, and their autoconnect
+// entries become targets. Host errors are logged when they change.
+func (d *daemon) addEntries(found []Entry, errs []hostError) {
+	for _, he := range errs {
+		if msg := he.err.Error(); d.hostErrs[he.host] != msg {
+			d.hostErrs[he.host] = msg
+			d.logger.Printf("ERR %s: %v", he.host, he.err)
+		}
+	}
+	var fresh []Entry
+	newHosts := make(map[string]bool)
+	for _, e := range found {
+		if !d.hosts[e.Host] {
+			fresh = append(fresh, e)
+			newHosts[e.Host] = true
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	// Assign over everything discovered, so saved and pinned ports are
+	// honored across discoveries; only the warnings about new entries are
+	// news.
+	all := append(append([]Entry(nil), d.entries...), fresh...)
+	assigned := make(map[string]Entry, len(all))
+	for _, w := range AssignPorts(all, d.ports) {
+		if newHosts[w.Host] {
+			d.logger.Println(formatLogEntry(logEntry{tag: "WARN", text: w.String()}))
+		}
+	}
+	for _, e := range all {
+		assigned[tunnelKey(&e)] = e
+	}
+	for i := range fresh {
+		fresh[i] = assigned[tunnelKey(&fresh[i])]
+	}
+	if err := d.ports.save(); err != nil {
+		d.logger.Println(formatLogEntry(logEntry{tag: "WARN", text: err.Error()}))
+	}
+
+	for host := range newHosts {
+		d.hosts[host] = true
+		delete(d.hostErrs, host)
+	}
+	d.entries = append(d.entries, fresh...)
+	saveDiscoveryCache(d.configPath, d.entries)
+	d.targets = append(d.targets, autoconnectEntries(d.cfg, fresh)...)
+}
+
+// missingHosts returns the configured hosts without entries.
+func (d *daemon) missingHosts() []HostConfig {
+	var out []HostConfig
+	for _, hc := range d.cfg.Hosts {
+		if !d.hosts[hc.Name] {
+			out = append(out, hc)
+		}
+	}
+	return out
+}
+
+// rediscover starts a discovery of the hosts without entries in the
+// background, unless one is running or the last was too recent.
+func (d *daemon) rediscover() {
+	if d.discovering || time.Since(d.lastDiscover) < daemonRediscoverInterval {
+		return
+	}
+	hosts := d.missingHosts()
+	if len(hosts) == 0 {
+		return
+	}
+	d.discovering = true
+	d.lastDiscover = time.Now()
+	go func() {
+		entries, errs := collectDiscovery(hosts, nil)
+		select {
+		case d.discovered <- discoverResult{entries, errs}:
+		case <-d.done:
+		}
+	}()
+}
+
+// connect starts connecting e in the background; connectDone records the
+// outcome. The connect works on a copy, so the entry is only ever changed
+// by the daemon's loop.
+func (d *daemon) connect(e *Entry) {
+	key := tunnelKey(e)
+	if d.pending[key] {
+		return
+	}
+	d.pending[key] = true
+	e.Status = StatusConnecting
+	c := *e
+	go func() {
+		msg := d.tm.Connect(&c)()
+		select {
+		case d.connected <- connectResult{e: e, ip: c.ContainerIP, msg: msg}:
+		case <-d.done:
+		}
+	}()
+}
+
+// connectDone records the outcome of a connect and logs it. Repeated
+// identical errors are only logged once.
+func (d *daemon) connectDone(r connectResult) {
+	e := r.e
+	delete(d.pending, tunnelKey(e))
+	switch msg := r.msg.(type) {
+	case tunnelConnectedMsg:
+		e.ContainerIP = firstNonEmpty(r.ip, e.ContainerIP)
+		e.Status = StatusConnected
+		e.Error = ""
+		d.logger.Printf("UP   %s/%s → localhost:%d", e.Host, e.Container, e.LocalPort)
+	case tunnelErrorMsg:
+		prevErr := e.Error
+		e.Status = StatusError
+		e.Error = msg.err.Error()
+		if e.Error != prevErr {
+			d.logger.Printf("ERR  %s/%s: %v", e.Host, e.Container, msg.err)
+		}
+	}
+}
+
+// syncTunnels compares each target's last known status with the
+// TunnelManager, logging transitions. Tunnels whose background monitor
+// has given up (or that never came up) are retried in the background,
+// mirroring what checkTunnelHealth does for the TUI.
+func (d *daemon) syncTunnels() {
+	for _, e := range d.targets {
+		if d.pending[tunnelKey(e)] {
+			continue
+		}
+		switch d.tm.Status(tunnelKey(e)) {
+		case TunnelAlive:
+			if e.Status != StatusConnected {
+				e.Status = StatusConnected
+				e.Error = ""
+				d.logger.Printf("UP   %s/%s → localhost:%d", e.Host, e.Container, e.LocalPort)
+			}
+		case TunnelReconnecting:
+			if e.Status != StatusConnecting {
+				e.Status = StatusConnecting
+				d.logger.Printf("DOWN %s/%s — reconnecting", e.Host, e.Container)
+			}
+		case TunnelNone:
+			d.connect(e)
+		}
+	}
+}
+
+// autoconnectEntries returns pointers to the discovered entries that are
+// marked auto:true in the config, in discovery order.
+func autoconnectEntries(cfg *Config, entries []Entry) []*Entry {
+	auto := make(map[string]bool)
+	for _, ac := range cfg.Autoconnect() {
+		auto[ac.Host+":"+ac.Container] = true
+	}
+	var out []*Entry
+	for i := range entries {
+		if auto[tunnelKey(&entries[i])] {
+			out = append(out, &entries[i])
+		}
+	}
+	return out
+}
+
+// formatLogEntry renders a discovery log line as plain text.
+func formatLogEntry(l logEntry) string {
+	if l.tag == "" {
+		return l.text
+	}
+	return fmt.Sprintf("%-4s %s", l.tag, l.text)
+}
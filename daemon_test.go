@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAutoconnectEntries(t *testing.T) {
+	cfg := &Config{
+		Hosts: []HostConfig{
+			{
+				Name: "server1",
+				Databases: []DatabaseOverride{
+					{Container: "db1", Auto: true},
+					{Container: "db2", Auto: false},
+				},
+			},
+			{
+				Name: "server2",
+				Databases: []DatabaseOverride{
+					{Container: "missing", Auto: true},
+				},
+			},
+		},
+	}
+	entries := []Entry{
+		{Host: "server1", Container: "db1"},
+		{Host: "server1", Container: "db2"},
+		{Host: "server2", Container: "db1"},
+	}
+
+	got := autoconnectEntries(cfg, entries)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 autoconnect entry, got %d", len(got))
+	}
+	if got[0] != &entries[0] {
+		t.Error("autoconnectEntries should return pointers into the entries slice")
+	}
+}
+
+func TestFormatLogEntry(t *testing.T) {
+	tests := []struct {
+		entry logEntry
+		want  string
+	}{
+		{logEntry{tag: "OK", text: "server1 — secure channel open"}, "OK   server1 — secure channel open"},
+		{logEntry{tag: "SCAN", text: "server1"}, "SCAN server1"},
+		{logEntry{text: "  server1/db1 ← postgres"}, "  server1/db1 ← postgres"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatLogEntry(tt.entry); got != tt.want {
+				t.Errorf("formatLogEntry() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemonAddEntries(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := &Config{
+		PortRange: "20000-20099",
+		Hosts: []HostConfig{
+			{Name: "server1", Databases: []DatabaseOverride{{Container: "db1", Auto: true}}},
+			{Name: "server2", Databases: []DatabaseOverride{{Container: "db1", Auto: true}}},
+		},
+	}
+	var logs bytes.Buffer
+	d := newDaemon(cfg, configPath, log.New(&logs, "", 0), nil)
+
+	d.addEntries([]Entry{{Host: "server1", Container: "db1"}, {Host: "server1", Container: "db2"}},
+		[]hostError{{host: "server2", err: errors.New("connection refused")}})
+	if len(d.targets) != 1 || d.targets[0].LocalPort == 0 {
+		t.Fatalf("targets = %+v", d.targets)
+	}
+	port := d.targets[0].LocalPort
+	if missing := d.missingHosts(); len(missing) != 1 || missing[0].Name != "server2" {
+		t.Errorf("missingHosts = %+v, want server2", missing)
+	}
+
+	// The same error again isn't logged twice.
+	d.addEntries(nil, []hostError{{host: "server2", err: errors.New("connection refused")}})
+	if n := strings.Count(logs.String(), "connection refused"); n != 1 {
+		t.Errorf("error logged %d times:\n%s", n, logs.String())
+	}
+
+	// server2 comes up; server1's entries are already known.
+	d.addEntries([]Entry{{Host: "server2", Container: "db1"}, {Host: "server1", Container: "db1"}}, nil)
+	if len(d.targets) != 2 || d.targets[0].LocalPort != port {
+		t.Fatalf("targets after rediscovery = %+v", d.targets)
+	}
+	if d.targets[1].Host != "server2" || d.targets[1].LocalPort == 0 || d.targets[1].LocalPort == port {
+		t.Errorf("server2/db1 = %+v", d.targets[1])
+	}
+	if len(d.missingHosts()) != 0 {
+		t.Error("hosts still missing")
+	}
+	if saved := loadPortState(cfg, configPath); len(saved.Ports) != 3 {
+		t.Errorf("saved ports = %v", saved.Ports)
+	}
+}
+
+func TestDaemonConnectDone(t *testing.T) {
+	var logs bytes.Buffer
+	d := newDaemon(&Config{}, filepath.Join(t.TempDir(), "config.yaml"), log.New(&logs, "", 0), nil)
+	e := &Entry{Host: "server1", Container: "db1", LocalPort: 15432}
+	d.pending[tunnelKey(e)] = true
+
+	for range 2 {
+		d.connectDone(connectResult{e: e, msg: tunnelErrorMsg{key: tunnelKey(e), err: errors.New("refused")}})
+	}
+	if e.Status != StatusError || strings.Count(logs.String(), "refused") != 1 || d.pending[tunnelKey(e)] {
+		t.Errorf("after errors: entry %+v, logs %q", e, logs.String())
+	}
+	d.connectDone(connectResult{e: e, ip: "172.18.0.2", msg: tunnelConnectedMsg{key: tunnelKey(e)}})
+	if e.Status != StatusConnected || e.Error != "" || e.ContainerIP != "172.18.0.2" {
+		t.Errorf("after connect: %+v", e)
+	}
+}
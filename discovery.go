@@ -1,13 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	tea "charm.land/bubbletea/v2"
-	"golang.org/x/crypto/ssh"
 )
 
 // Entry represents a single discovered database container.
@@ -16,14 +17,24 @@ type Entry struct {
 	Host        string // SSH host alias (for display)
 	SSHHost     string // user@host or just host (for SSH commands)
 	Container   string // Docker container name
-	Image       string // Docker image (postgres, postgis, timescale)
+	Image       string // image type for the badge (postgres, postgis, timescale)
 	DBUser      string // postgres user (default: "postgres")
 	Password    string // POSTGRES_PASSWORD from container env
 	Database    string // database name (default: container name)
-	ContainerIP string // resolved at connect time
+	ContainerIP string // from discovery; resolved again when a tunnel reconnects
 	LocalPort   uint16
+	PinnedPort  uint16 // port: from the override, 0 if the port is assigned
 	Status      Status
 	Error       string
+
+	runtime HostRuntime // settings of the host, for commands run for this entry
+	docker  string      // Docker container name, if a drillbit.name label renamed the entry
+}
+
+// dockerName returns the name of the Docker container behind e, for
+// docker commands.
+func (e *Entry) dockerName() string {
+	return firstNonEmpty(e.docker, e.Container)
 }
 
 // Status represents the connection state of a tunnel.
@@ -36,6 +47,21 @@ const (
 	StatusError
 )
 
+// String returns the lowercase status name used in scriptable output.
+func (s Status) String() string {
+	switch s {
+	case StatusReady:
+		return "ready"
+	case StatusConnecting:
+		return "connecting"
+	case StatusConnected:
+		return "connected"
+	case StatusError:
+		return "error"
+	}
+	return "unknown"
+}
+
 type hostError struct {
 	host string
 	err  error
@@ -73,11 +99,18 @@ func nextDiscoverEvent(ch <-chan discoverUpdate) tea.Cmd {
 // discoverAll runs discovery across all configured hosts concurrently,
 // streaming progress events through a channel.
 func discoverAll(cfg *Config) tea.Cmd {
+	return nextDiscoverEvent(streamDiscovery(cfg.Hosts))
+}
+
+// streamDiscovery starts discovery on every host concurrently and returns
+// the channel progress events arrive on. The channel is closed once all
+// hosts have finished.
+func streamDiscovery(hosts []HostConfig) <-chan discoverUpdate {
 	ch := make(chan discoverUpdate, 50)
 
 	go func() {
 		var wg sync.WaitGroup
-		for _, hc := range cfg.Hosts {
+		for _, hc := range hosts {
 			wg.Add(1)
 			go func(hc HostConfig) {
 				defer wg.Done()
@@ -88,7 +121,40 @@ func discoverAll(cfg *Config) tea.Cmd {
 		close(ch)
 	}()
 
-	return nextDiscoverEvent(ch)
+	return ch
+}
+
+// discoverSync runs discovery on the given hosts to completion without a UI.
+// onLog, if set, is called for every progress line, including a "WARN" line
+// for each pinned port that couldn't be used. Returned entries have ports
+// assigned from ports; nil assigns them from scratch. ports isn't saved:
+// that is up to a caller holding the instance lock.
+func discoverSync(hosts []HostConfig, ports *portState, onLog func(logEntry)) ([]Entry, []hostError) {
+	entries, errs := collectDiscovery(hosts, onLog)
+	for _, w := range AssignPorts(entries, ports) {
+		if onLog != nil {
+			onLog(logEntry{tag: "WARN", text: w.String()})
+		}
+	}
+	return entries, errs
+}
+
+// collectDiscovery runs discovery on the given hosts to completion and
+// returns the entries, without local ports, and the host errors. onLog,
+// if set, is called for every progress line.
+func collectDiscovery(hosts []HostConfig, onLog func(logEntry)) ([]Entry, []hostError) {
+	var entries []Entry
+	var errs []hostError
+	for u := range streamDiscovery(hosts) {
+		if u.log != nil && onLog != nil {
+			onLog(*u.log)
+		}
+		entries = append(entries, u.entries...)
+		if u.hostErr != nil {
+			errs = append(errs, *u.hostErr)
+		}
+	}
+	return entries, errs
 }
 
 // discoverHostStreaming discovers containers on a host, sending progress to ch.
@@ -111,8 +177,8 @@ func discoverHostStreaming(hc HostConfig, ch chan<- discoverUpdate) {
 	ch <- discoverUpdate{log: &logEntry{tag: "OK", text: fmt.Sprintf("%s — secure channel open", hc.Name)}}
 	ch <- discoverUpdate{log: &logEntry{tag: "SCAN", text: fmt.Sprintf("%s — interrogating docker daemon...", hc.Name)}}
 
-	docker := dockerCmd(client)
-	containers, err := discoverDockerContainers(client, docker)
+	engine := newContainerEngine(client, hc.HostRuntime)
+	containers, err := discoverDockerContainers(engine, hc.discoveryTimeout(), hc.discoveryRules())
 	if err != nil {
 		ch <- discoverUpdate{
 			log:      &logEntry{tag: "ERR", text: fmt.Sprintf("%s — %v", hc.Name, err)},
@@ -137,10 +203,20 @@ func discoverHostStreaming(hc HostConfig, ch chan<- discoverUpdate) {
 	}
 	ch <- discoverUpdate{log: &logEntry{tag: "FIND", text: fmt.Sprintf("%s — %d %s acquired", hc.Name, n, word)}}
 
-	// Build entries with overrides.
+	// Build entries with labels and overrides.
 	var entries []Entry
+	names := make(map[string]bool)
 	for _, c := range containers {
-		override := hc.GetOverride(c.name)
+		for _, p := range c.labels.problems {
+			ch <- discoverUpdate{log: &logEntry{tag: "WARN", text: fmt.Sprintf("%s/%s — %s", hc.Name, c.name, p)}}
+		}
+		name := firstNonEmpty(c.labels.name, c.name)
+		if names[name] {
+			ch <- discoverUpdate{log: &logEntry{tag: "WARN", text: fmt.Sprintf("%s/%s — name %q from %s is already taken on this host", hc.Name, c.name, name, labelName)}}
+			name = c.name
+		}
+		names[name] = true
+		override := hc.GetOverride(name)
 
 		dbUser := c.dbUser
 		if override != nil && override.User != "" {
@@ -149,9 +225,27 @@ func discoverHostStreaming(hc HostConfig, ch chan<- discoverUpdate) {
 			dbUser = "postgres"
 		}
 
+		// Password overrides may be secret references. If one can't be
+		// resolved, keep the detected password and flag the entry.
 		password := c.password
+		status, errMsg := StatusReady, ""
 		if override != nil && override.Password != "" {
-			password = override.Password
+			if pw, err := resolveSecret(override.Password, override.passwordShared); err != nil {
+				status, errMsg = StatusError, fmt.Sprintf("password override %v", err)
+				ch <- discoverUpdate{log: &logEntry{tag: "ERR", text: fmt.Sprintf("%s/%s — %s", hc.Name, c.name, errMsg)}}
+			} else {
+				password = pw
+			}
+		}
+
+		// A secret file that couldn't be read only matters if no
+		// override stands in for it.
+		for _, se := range c.secretErrs {
+			if status == StatusError || override.sets(se.field) {
+				continue
+			}
+			status, errMsg = StatusError, se.msg
+			ch <- discoverUpdate{log: &logEntry{tag: "ERR", text: fmt.Sprintf("%s/%s — %s", hc.Name, c.name, errMsg)}}
 		}
 
 		database := c.database
@@ -161,131 +255,98 @@ func discoverHostStreaming(hc HostConfig, ch chan<- discoverUpdate) {
 			database = c.name
 		}
 
+		pinned := c.labels.port
+		if override != nil && override.Port != 0 {
+			pinned = override.Port
+		}
+
+		var docker string
+		if name != c.name {
+			docker = c.name
+		}
+
 		entries = append(entries, Entry{
-			Env:       hc.Env,
-			Host:      hc.Name,
-			SSHHost:   sshHost,
-			Container: c.name,
-			Image:     c.image,
-			DBUser:    dbUser,
-			Password:  password,
-			Database:  database,
-			Status:    StatusReady,
+			Env:         firstNonEmpty(c.labels.env, hc.Env),
+			Host:        hc.Name,
+			SSHHost:     sshHost,
+			Container:   name,
+			Image:       c.image,
+			DBUser:      dbUser,
+			Password:    password,
+			Database:    database,
+			PinnedPort:  pinned,
+			ContainerIP: c.ip,
+			Status:      status,
+			Error:       errMsg,
+			runtime:     hc.HostRuntime,
+			docker:      docker,
 		})
 
-		ch <- discoverUpdate{log: &logEntry{tag: "", text: fmt.Sprintf("  %s/%s \u2190 %s", hc.Name, c.name, c.image)}}
+		ch <- discoverUpdate{log: &logEntry{tag: "", text: fmt.Sprintf("  %s/%s \u2190 %s", hc.Name, name, c.image)}}
 	}
 
 	ch <- discoverUpdate{entries: entries, hostDone: true}
 }
 
 type containerInfo struct {
-	name     string
-	image    string
-	dbUser   string
-	password string
-	database string
+	name        string
+	image       string
+	dbUser      string
+	password    string
+	database    string
+	passwordEnv []string // where the password was looked for
+	ip          string   // on the container's first network
+	ports       []string // published ports, e.g. "5432/tcp -> 0.0.0.0:15432"
+	compose     string   // compose project/service, if any
+
+	secrets    []secretFile  // credentials still to be read from files
+	secretErrs []secretError // secret files that couldn't be read
+	labels     containerLabels
 }
 
-// discoverDockerContainers queries Docker API directly for Postgres containers.
-// docker is the command prefix ("docker" or "sudo docker").
-func discoverDockerContainers(client *ssh.Client, docker string) ([]containerInfo, error) {
-	script := `
-# Find all running containers and filter for postgres-related images
-containers=$(` + docker + ` ps --format '{{.ID}}|{{.Image}}' 2>/dev/null || true)
-if [ -z "$containers" ]; then
-    exit 0
-fi
-
-# Filter for postgres, postgis, timescale images
-echo "$containers" | grep -iE 'postgres|postgis|timescale' | while IFS='|' read -r cid image; do
-    # Extract name, image, and environment variables
-    ` + docker + ` inspect "$cid" --format '{{.Name}}|||'"$image"'|||{{range .Config.Env}}{{println .}}{{end}}' 2>/dev/null || continue
-    echo "%%%REC%%%"
-done
-`
-
-	session, err := client.NewSession()
+// discoverDockerContainers asks the host's container engine for database
+// containers that have credentials. rules say which containers count
+// (see ruleFor).
+func discoverDockerContainers(engine containerEngine, timeout time.Duration, rules []discoveryRule) ([]containerInfo, error) {
+	containers, err := inspectDockerContainers(engine, timeout, rules)
 	if err != nil {
-		return nil, fmt.Errorf("session: %w", err)
+		return nil, err
 	}
-	defer session.Close()
-
-	type result struct {
-		out []byte
-		err error
-	}
-	ch := make(chan result, 1)
-	go func() {
-		out, err := session.Output(script)
-		ch <- result{out, err}
-	}()
+	return withPassword(containers), nil
+}
 
-	select {
-	case r := <-ch:
-		if r.err != nil {
-			return nil, fmt.Errorf("docker inspect: %w", r.err)
-		}
-		return parseDockerContainers(r.out), nil
-	case <-time.After(30 * time.Second):
-		session.Close()
-		return nil, fmt.Errorf("timeout querying docker")
+// inspectDockerContainers returns every running container a discovery
+// rule matches, including those without a password. The environments of
+// containers no rule matches are dropped unread; see containerInfos.
+func inspectDockerContainers(engine containerEngine, timeout time.Duration, rules []discoveryRule) ([]containerInfo, error) {
+	deadline := time.Now().Add(timeout)
+	inspected, err := engine.containers(rules, timeout)
+	if err != nil {
+		return nil, err
 	}
+	containers := containerInfos(inspected, rules)
+	readSecretFiles(engine, deadline, containers)
+	return containers, nil
 }
 
-// parseDockerContainers parses docker inspect output into containerInfo records.
-// Each record is delimited by %%%REC%%%, and fields within a record by |||.
-// Format per record: name|||image|||env1\nenv2\nenv3...
-func parseDockerContainers(out []byte) []containerInfo {
-	var containers []containerInfo
-
-	records := strings.Split(string(out), "%%%REC%%%")
-	for _, rec := range records {
-		rec = strings.TrimSpace(rec)
-		if rec == "" {
-			continue
-		}
-
-		parts := strings.SplitN(rec, "|||", 3)
-		if len(parts) < 3 {
-			continue
-		}
-
-		// First part is container name (e.g., /myapp_db_1)
-		name := strings.TrimPrefix(strings.TrimSpace(parts[0]), "/")
-		if name == "" {
-			continue
-		}
-
-		// Second part is image name
-		imageType := simplifyImageName(strings.TrimSpace(parts[1]))
-
-		// Third part is environment variables
-		info := containerInfo{
-			name:     name,
-			image:    imageType,
-			dbUser:   "postgres", // default
-			database: name,       // default to container name
-		}
-
-		for _, line := range strings.Split(parts[2], "\n") {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "POSTGRES_USER=") {
-				info.dbUser = strings.TrimPrefix(line, "POSTGRES_USER=")
-			} else if strings.HasPrefix(line, "POSTGRES_PASSWORD=") {
-				info.password = strings.TrimPrefix(line, "POSTGRES_PASSWORD=")
-			} else if strings.HasPrefix(line, "POSTGRES_DB=") {
-				info.database = strings.TrimPrefix(line, "POSTGRES_DB=")
-			}
-		}
+// parseDockerContainers parses docker inspect output, keeping only
+// containers with a password.
+func parseDockerContainers(out []byte, rules []discoveryRule) ([]containerInfo, error) {
+	containers, err := parseDockerInspect(out, rules)
+	return withPassword(containers), err
+}
 
+// withPassword filters out containers without a password. Containers
+// whose secret files couldn't be read stay, to show why.
+func withPassword(containers []containerInfo) []containerInfo {
+	var out []containerInfo
+	for _, c := range containers {
 		// Only include if we found a password (prevents showing containers without creds)
-		if info.password != "" {
-			containers = append(containers, info)
+		if c.password != "" || len(c.secretErrs) > 0 {
+			out = append(out, c)
 		}
 	}
-
-	return containers
+	return out
 }
 
 // simplifyImageName converts full image names to simple types for display.
@@ -312,3 +373,42 @@ func simplifyImageName(image string) string {
 
 	return image
 }
+
+// discoveryCache records the last discovery results so shell completion
+// can offer host/container names without connecting to anything.
+type discoveryCache struct {
+	Updated time.Time `json:"updated"`
+	Targets []string  `json:"targets"` // host/container
+}
+
+// discoveryCachePath returns the cache file location for a config file.
+func discoveryCachePath(configPath string) string {
+	return configStatePath(configPath, "discovery-cache.json")
+}
+
+// saveDiscoveryCache writes the discovered targets. Best-effort: a stale
+// or missing cache only degrades completion.
+func saveDiscoveryCache(configPath string, entries []Entry) {
+	cache := discoveryCache{Updated: time.Now()}
+	for _, e := range entries {
+		cache.Targets = append(cache.Targets, e.Host+"/"+e.Container)
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	os.WriteFile(discoveryCachePath(configPath), data, 0o600)
+}
+
+// loadDiscoveryCache reads the cache written by saveDiscoveryCache.
+func loadDiscoveryCache(configPath string) (*discoveryCache, error) {
+	data, err := os.ReadFile(discoveryCachePath(configPath))
+	if err != nil {
+		return nil, err
+	}
+	var cache discoveryCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
@@ -5,14 +5,15 @@ import (
 )
 
 func TestParseDockerContainers(t *testing.T) {
+	rules := compileRules(builtinDiscoveryRules)
+
 	t.Run("single container", func(t *testing.T) {
-		input := `/myapp_db_1|||postgres:16|||POSTGRES_USER=admin
-POSTGRES_PASSWORD=secret123
-POSTGRES_DB=myapp
-PATH=/usr/bin
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
+		input := inspectJSON(t, runningContainer("myapp_db_1", "postgres:16",
+			"POSTGRES_USER=admin", "POSTGRES_PASSWORD=secret123", "POSTGRES_DB=myapp", "PATH=/usr/bin"))
+		containers, err := parseDockerContainers(input, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(containers) != 1 {
 			t.Fatalf("expected 1 container, got %d", len(containers))
 		}
@@ -35,13 +36,14 @@ PATH=/usr/bin
 	})
 
 	t.Run("multiple containers", func(t *testing.T) {
-		input := `/db1|||postgres:16|||POSTGRES_PASSWORD=pass1
-%%%REC%%%
-/db2|||postgis/postgis:latest|||POSTGRES_PASSWORD=pass2
-POSTGRES_DB=geodb
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
+		input := inspectJSON(t,
+			runningContainer("db1", "postgres:16", "POSTGRES_PASSWORD=pass1"),
+			runningContainer("db2", "postgis/postgis:latest", "POSTGRES_PASSWORD=pass2", "POSTGRES_DB=geodb"),
+		)
+		containers, err := parseDockerContainers(input, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(containers) != 2 {
 			t.Fatalf("expected 2 containers, got %d", len(containers))
 		}
@@ -57,10 +59,11 @@ POSTGRES_DB=geodb
 	})
 
 	t.Run("defaults", func(t *testing.T) {
-		input := `/mydb|||postgres:16|||POSTGRES_PASSWORD=pass
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
+		input := inspectJSON(t, runningContainer("mydb", "postgres:16", "POSTGRES_PASSWORD=pass"))
+		containers, err := parseDockerContainers(input, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(containers) != 1 {
 			t.Fatalf("expected 1 container, got %d", len(containers))
 		}
@@ -73,44 +76,48 @@ POSTGRES_DB=geodb
 	})
 
 	t.Run("skips containers without password", func(t *testing.T) {
-		input := `/db_no_pass|||postgres:16|||POSTGRES_USER=admin
-%%%REC%%%
-/db_with_pass|||postgres:16|||POSTGRES_PASSWORD=secret
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
+		input := inspectJSON(t,
+			runningContainer("db_no_pass", "postgres:16", "POSTGRES_USER=admin"),
+			runningContainer("db_with_pass", "postgres:16", "POSTGRES_PASSWORD=secret"),
+		)
+		containers, err := parseDockerContainers(input, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(containers) != 1 {
 			t.Fatalf("expected 1 container (with password), got %d", len(containers))
 		}
 		if containers[0].name != "db_with_pass" {
 			t.Errorf("name = %q, want %q", containers[0].name, "db_with_pass")
 		}
+
+		// parseDockerInspect keeps them so doctor can report what was dropped.
+		if all, _ := parseDockerInspect(input, rules); len(all) != 2 || all[0].name != "db_no_pass" {
+			t.Errorf("parseDockerInspect = %+v, want both containers", all)
+		}
 	})
 
 	t.Run("empty input", func(t *testing.T) {
-		containers := parseDockerContainers([]byte(""))
-		if len(containers) != 0 {
-			t.Errorf("expected 0 containers, got %d", len(containers))
+		for _, input := range []string{"", "[]"} {
+			containers, err := parseDockerContainers([]byte(input), rules)
+			if err != nil || len(containers) != 0 {
+				t.Errorf("parseDockerContainers(%q) = %+v, %v; want none", input, containers, err)
+			}
 		}
 	})
 
-	t.Run("malformed records", func(t *testing.T) {
-		input := `incomplete|||data
-%%%REC%%%
-|||
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
-		if len(containers) != 0 {
-			t.Errorf("expected 0 containers from malformed input, got %d", len(containers))
+	t.Run("malformed output", func(t *testing.T) {
+		if _, err := parseDockerContainers([]byte("Error: No such object: db"), rules); err == nil {
+			t.Error("expected an error for output that isn't JSON")
 		}
 	})
 
 	t.Run("strips leading slash from name", func(t *testing.T) {
-		input := `/my-container|||postgres:16|||POSTGRES_PASSWORD=pass
-%%%REC%%%
-`
-		containers := parseDockerContainers([]byte(input))
+		input := inspectJSON(t, runningContainer("my-container", "postgres:16", "POSTGRES_PASSWORD=pass"))
+		containers, err := parseDockerContainers(input, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(containers) != 1 {
 			t.Fatalf("expected 1 container, got %d", len(containers))
 		}
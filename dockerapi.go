@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// dockerAPI is a containerEngine that speaks the Docker Engine API on a
+// unix socket of the host, forwarded over ssh (direct-streamlocal), so no
+// command lines are built and no CLI output is parsed. Paths carry no
+// API version, so the daemon answers in its own; podman's
+// docker-compatible socket works as well.
+type dockerAPI struct {
+	socket string                   // path on the host, for messages
+	dial   func() (net.Conn, error) // opens a connection to the socket
+	client *http.Client
+}
+
+// dockerAPIBase is the scheme and host of request URLs. The host is
+// never resolved; every connection goes to the socket.
+const dockerAPIBase = "http://docker"
+
+func newDockerAPI(socket string, dial func() (net.Conn, error)) *dockerAPI {
+	return &dockerAPI{
+		socket: socket,
+		dial:   dial,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return dial()
+			},
+			IdleConnTimeout: 30 * time.Second,
+		}},
+	}
+}
+
+func (a *dockerAPI) String() string { return "docker API at " + a.socket }
+
+// dockerAPIError is an error response from the daemon.
+type dockerAPIError struct {
+	status  int
+	message string // e.g. "No such container: app_db"
+}
+
+func (e *dockerAPIError) Error() string { return e.message }
+
+// apiError reads an error response.
+func apiError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string `json:"message"`
+	}
+	msg := resp.Status
+	if json.Unmarshal(b, &body) == nil && body.Message != "" {
+		msg = body.Message
+	} else if s := strings.TrimSpace(string(b)); s != "" {
+		msg = resp.Status + ": " + firstLine(s)
+	}
+	return &dockerAPIError{status: resp.StatusCode, message: msg}
+}
+
+// isNotFound reports whether err is the daemon saying a container or
+// exec doesn't exist.
+func isNotFound(err error) bool {
+	var apiErr *dockerAPIError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+// request sends a request, with body encoded as JSON unless it is nil,
+// and checks the response status. The caller closes the response body.
+func (a *dockerAPI) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	u := dockerAPIBase + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%s: %w", a, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// do sends a request and decodes the JSON response into out, unless out
+// is nil.
+func (a *dockerAPI) do(method, path string, query url.Values, body, out any, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := a.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// dockerVersion is the part of GET /version doctor reports.
+type dockerVersion struct {
+	Version    string `json:"Version"`
+	APIVersion string `json:"ApiVersion"`
+}
+
+func (a *dockerAPI) version(timeout time.Duration) (dockerVersion, error) {
+	var v dockerVersion
+	err := a.do(http.MethodGet, "/version", nil, nil, &v, timeout)
+	return v, err
+}
+
+// dockerContainerSummary is an entry of GET /containers/json.
+type dockerContainerSummary struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"` // "/app_db", then any link names
+	Image string   `json:"Image"`
+}
+
+// containers lists the running containers and inspects only those a rule
+// matches, so the environments of the others never leave the host.
+func (a *dockerAPI) containers(rules []discoveryRule, timeout time.Duration) ([]dockerContainer, error) {
+	deadline := time.Now().Add(timeout)
+	var list []dockerContainerSummary
+	if err := a.do(http.MethodGet, "/containers/json", nil, nil, &list, timeout); err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	var out []dockerContainer
+	for _, s := range list {
+		name := ""
+		if len(s.Names) > 0 {
+			name = strings.TrimPrefix(s.Names[0], "/")
+		}
+		if ruleFor(rules, s.Image, name) == nil {
+			continue
+		}
+		c, err := a.inspect(s.ID, time.Until(deadline))
+		if isNotFound(err) {
+			continue // removed since the list
+		}
+		if err != nil {
+			return nil, fmt.Errorf("inspecting %s: %w", name, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func (a *dockerAPI) inspect(name string, timeout time.Duration) (dockerContainer, error) {
+	var c dockerContainer
+	err := a.do(http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, &c, timeout)
+	return c, err
+}
+
+// dockerExecConfig is the body of POST /containers/{name}/exec.
+type dockerExecConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Cmd          []string `json:"Cmd"`
+}
+
+// dockerExecState is the part of GET /exec/{id}/json exec reads.
+type dockerExecState struct {
+	Running  bool `json:"Running"`
+	ExitCode int  `json:"ExitCode"`
+}
+
+// exec creates an exec instance and starts it on a connection of its
+// own, which the daemon hijacks for the command's stdin and output.
+func (a *dockerAPI) exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, timeout time.Duration) error {
+	requestTimeout := firstNonEmpty(timeout, defaultCommandTimeout)
+	var created struct {
+		ID string `json:"Id"`
+	}
+	config := dockerExecConfig{AttachStdin: stdin != nil, AttachStdout: true, AttachStderr: true, Cmd: cmd}
+	if err := a.do(http.MethodPost, "/containers/"+url.PathEscape(name)+"/exec", nil, config, &created, requestTimeout); err != nil {
+		return err
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return fmt.Errorf("%s: %w", a, err)
+	}
+	defer conn.Close()
+	var expired atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			expired.Store(true)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dockerAPIBase+"/exec/"+created.ID+"/start", strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("%s: starting exec: %w", a, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		if expired.Load() {
+			return fmt.Errorf("command timed out after %s", timeout)
+		}
+		return fmt.Errorf("%s: starting exec: %w", a, err)
+	}
+	var output io.Reader = br // the raw stream once the connection is upgraded
+	switch resp.StatusCode {
+	case http.StatusSwitchingProtocols:
+	case http.StatusOK:
+		output = resp.Body // daemons that don't upgrade stream the body
+	default:
+		defer resp.Body.Close()
+		return apiError(resp)
+	}
+
+	stdinDone := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(conn, stdin)
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+			stdinDone <- err
+		}()
+	} else {
+		stdinDone <- nil
+	}
+
+	stderr := &stderrBuffer{}
+	streamErr := demuxDockerStream(output, stdout, stderr)
+	if expired.Load() {
+		return fmt.Errorf("command timed out after %s", timeout)
+	}
+	conn.Close()
+	stdinErr := <-stdinDone
+	if streamErr != nil {
+		return fmt.Errorf("%s: reading output: %w", a, streamErr)
+	}
+
+	state, err := a.execState(created.ID, requestTimeout)
+	if err != nil {
+		return err
+	}
+	if state.ExitCode != 0 {
+		return execError(fmt.Errorf("exit status %d", state.ExitCode), stderr.String())
+	}
+	if stdinErr != nil {
+		return fmt.Errorf("writing stdin: %w", stdinErr)
+	}
+	return nil
+}
+
+// execState waits briefly for an exec whose output has ended to be
+// reported as finished, and returns its state.
+func (a *dockerAPI) execState(id string, timeout time.Duration) (dockerExecState, error) {
+	var state dockerExecState
+	for range 20 {
+		if err := a.do(http.MethodGet, "/exec/"+id+"/json", nil, nil, &state, timeout); err != nil {
+			return state, err
+		}
+		if !state.Running {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return state, nil
+}
+
+// Stream types in the header of a multiplexed exec output frame.
+const (
+	dockerStreamStdout = 1
+	dockerStreamStderr = 2
+)
+
+// demuxDockerStream copies the output of an exec without a tty to stdout
+// and stderr. The daemon sends it in frames of an 8-byte header, the
+// stream type and a big-endian payload size, followed by the payload.
+func demuxDockerStream(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var w io.Writer
+		switch header[0] {
+		case dockerStreamStdout:
+			w = stdout
+		case dockerStreamStderr:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// dockerEvent is a message from GET /events.
+type dockerEvent struct {
+	Type   string `json:"Type"`   // "container", "network", ...
+	Action string `json:"Action"` // "start", "die", ...
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"` // name, image and labels
+	} `json:"Actor"`
+}
+
+// events streams the events that match filters, in the form of the
+// API's filters parameter, e.g. {"event": ["start"]}, until stop is
+// closed or the connection ends; then the channel is closed.
+func (a *dockerAPI) events(filters map[string][]string, stop <-chan struct{}) (<-chan dockerEvent, error) {
+	f, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := a.request(ctx, http.MethodGet, "/events", url.Values{"filters": {string(f)}}, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	ch := make(chan dockerEvent)
+	go func() {
+		defer close(ch)
+		defer cancel()
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev dockerEvent
+			if err := dec.Decode(&ev); err != nil {
+				return
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker is an in-process stand-in for the Docker Engine API, with
+// as much of it as dockerAPI uses.
+type fakeDocker struct {
+	containers []dockerContainer // the running containers
+	// run plays the command of an exec and returns its exit code.
+	run    func(container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) int
+	events chan dockerEvent // sent to every /events stream
+
+	mu        sync.Mutex
+	inspected []string // containers inspected, by name or ID
+	execs     map[string]*fakeExec
+	filters   []string // filters parameters of /events
+}
+
+type fakeExec struct {
+	container string
+	config    dockerExecConfig
+	running   bool
+	exitCode  int
+}
+
+// newFakeDocker serves f and returns a dockerAPI connected to it.
+func newFakeDocker(t *testing.T, f *fakeDocker) *dockerAPI {
+	t.Helper()
+	f.execs = make(map[string]*fakeExec)
+	if f.events == nil {
+		f.events = make(chan dockerEvent)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, dockerVersion{Version: "27.3.1", APIVersion: "1.47"})
+	})
+	mux.HandleFunc("GET /containers/json", f.list)
+	mux.HandleFunc("GET /containers/{name}/json", f.inspect)
+	mux.HandleFunc("POST /containers/{name}/exec", f.createExec)
+	mux.HandleFunc("POST /exec/{id}/start", f.startExec)
+	mux.HandleFunc("GET /exec/{id}/json", f.execState)
+	mux.HandleFunc("GET /events", f.streamEvents)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return newDockerAPI("/var/run/docker.sock", func() (net.Conn, error) {
+		return net.Dial("tcp", srv.Listener.Addr().String())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter, format string, a ...any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf(format, a...)})
+}
+
+func (f *fakeDocker) find(name string) *dockerContainer {
+	for i := range f.containers {
+		if c := &f.containers[i]; c.ID == name || c.name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDocker) list(w http.ResponseWriter, r *http.Request) {
+	var list []dockerContainerSummary
+	for _, c := range f.containers {
+		list = append(list, dockerContainerSummary{ID: c.ID, Names: []string{c.Name}, Image: c.Config.Image})
+	}
+	writeJSON(w, list)
+}
+
+func (f *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	f.mu.Lock()
+	f.inspected = append(f.inspected, name)
+	f.mu.Unlock()
+	c := f.find(name)
+	if c == nil {
+		notFound(w, "No such container: %s", name)
+		return
+	}
+	writeJSON(w, c)
+}
+
+func (f *fakeDocker) createExec(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if f.find(name) == nil {
+		notFound(w, "No such container: %s", name)
+		return
+	}
+	var config dockerExecConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	id := fmt.Sprintf("exec%d", len(f.execs)+1)
+	f.execs[id] = &fakeExec{container: name, config: config}
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]string{"Id": id})
+}
+
+func (f *fakeDocker) startExec(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	e := f.execs[r.PathValue("id")]
+	f.mu.Unlock()
+	if e == nil {
+		notFound(w, "No such exec instance: %s", r.PathValue("id"))
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+	var stdin io.Reader = strings.NewReader("")
+	if e.config.AttachStdin {
+		stdin = brw.Reader
+	}
+	f.mu.Lock()
+	e.running = true
+	f.mu.Unlock()
+	var mu sync.Mutex // frames from stdout and stderr must not interleave
+	code := f.run(e.container, e.config.Cmd, stdin, &frameWriter{conn, dockerStreamStdout, &mu}, &frameWriter{conn, dockerStreamStderr, &mu})
+	f.mu.Lock()
+	e.running, e.exitCode = false, code
+	f.mu.Unlock()
+}
+
+func (f *fakeDocker) execState(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := f.execs[r.PathValue("id")]
+	if e == nil {
+		notFound(w, "No such exec instance: %s", r.PathValue("id"))
+		return
+	}
+	writeJSON(w, dockerExecState{Running: e.running, ExitCode: e.exitCode})
+}
+
+func (f *fakeDocker) streamEvents(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.filters = append(f.filters, r.URL.Query().Get("filters"))
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-f.events:
+			enc.Encode(ev)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// frameWriter writes one stream of a multiplexed exec output.
+type frameWriter struct {
+	w      io.Writer
+	stream byte
+	mu     *sync.Mutex
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	header := [8]byte{fw.stream}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(p)))
+	if _, err := fw.w.Write(append(header[:], p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func TestDockerAPIContainers(t *testing.T) {
+	db := runningContainer("app_db", "postgres:16", "POSTGRES_PASSWORD=pw")
+	db.ID = "aaa"
+	cache := runningContainer("cache", "redis:7", "REDIS_PASSWORD=secret")
+	cache.ID = "bbb"
+	f := &fakeDocker{containers: []dockerContainer{db, cache}}
+	api := newFakeDocker(t, f)
+
+	got, err := api.containers(compileRules(builtinDiscoveryRules), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].name() != "app_db" {
+		t.Fatalf("containers = %+v, want app_db", got)
+	}
+	// Containers no rule matches are never inspected.
+	if !slices.Equal(f.inspected, []string{"aaa"}) {
+		t.Errorf("inspected %q, want only aaa", f.inspected)
+	}
+
+	if _, err := api.inspect("missing", time.Second); !isNotFound(err) || err.Error() != "No such container: missing" {
+		t.Errorf("inspect missing = %v", err)
+	}
+}
+
+func TestDockerAPIVersion(t *testing.T) {
+	api := newFakeDocker(t, &fakeDocker{})
+	v, err := api.version(time.Second)
+	if err != nil || v.Version != "27.3.1" || v.APIVersion != "1.47" {
+		t.Errorf("version = %+v, %v", v, err)
+	}
+}
+
+func TestDockerAPIUnreachable(t *testing.T) {
+	api := newDockerAPI("/var/run/docker.sock", func() (net.Conn, error) {
+		return nil, fmt.Errorf("ssh: rejected: connect failed (open failed)")
+	})
+	_, err := api.version(time.Second)
+	if err == nil || err.Error() != "docker API at /var/run/docker.sock: ssh: rejected: connect failed (open failed)" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDockerAPIExec(t *testing.T) {
+	f := &fakeDocker{
+		containers: []dockerContainer{runningContainer("app_db", "postgres:16")},
+		run: func(container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) int {
+			switch cmd[0] {
+			case "cat":
+				in, _ := io.ReadAll(stdin)
+				stdout.Write(in)
+				return 0
+			case "psql":
+				fmt.Fprintln(stderr, `psql: error: FATAL:  role "nobody" does not exist`)
+				fmt.Fprintln(stderr, "more detail")
+				return 2
+			}
+			fmt.Fprintf(stdout, "%s in %s\n", strings.Join(cmd, " "), container)
+			return 0
+		},
+	}
+	api := newFakeDocker(t, f)
+
+	out, err := runInContainer(api, "app_db", []string{"echo", "it's"}, time.Second)
+	if err != nil || out != "echo it's in app_db" {
+		t.Errorf("runInContainer = %q, %v", out, err)
+	}
+
+	// stdin is streamed until EOF, as for a restore.
+	input := strings.Repeat("INSERT INTO t VALUES (1);\n", 10000)
+	var stdout bytes.Buffer
+	if err := api.exec("app_db", []string{"cat"}, strings.NewReader(input), &stdout, 0); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != input {
+		t.Errorf("got %d bytes back, want %d", stdout.Len(), len(input))
+	}
+
+	err = api.exec("app_db", []string{"psql", "-U", "nobody"}, nil, io.Discard, time.Second)
+	if err == nil || err.Error() != `psql: error: FATAL:  role "nobody" does not exist` {
+		t.Errorf("failed exec = %v", err)
+	}
+
+	if err := api.exec("gone", []string{"true"}, nil, io.Discard, time.Second); !isNotFound(err) {
+		t.Errorf("exec in missing container = %v", err)
+	}
+}
+
+func TestDockerAPIExecTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	f := &fakeDocker{
+		containers: []dockerContainer{runningContainer("app_db", "postgres:16")},
+		run: func(string, []string, io.Reader, io.Writer, io.Writer) int {
+			<-release
+			return 0
+		},
+	}
+	api := newFakeDocker(t, f)
+	err := api.exec("app_db", []string{"sleep", "60"}, nil, io.Discard, 50*time.Millisecond)
+	if err == nil || err.Error() != "command timed out after 50ms" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDockerAPIEvents(t *testing.T) {
+	f := &fakeDocker{}
+	api := newFakeDocker(t, f)
+	stop := make(chan struct{})
+	events, err := api.events(map[string][]string{"event": {"start"}}, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent dockerEvent
+	sent.Type, sent.Action, sent.Actor.ID = "container", "start", "aaa"
+	sent.Actor.Attributes = map[string]string{"name": "app_db"}
+	f.events <- sent
+
+	ev := <-events
+	if ev.Action != "start" || ev.Actor.Attributes["name"] != "app_db" {
+		t.Errorf("event = %+v", ev)
+	}
+	if want := `{"event":["start"]}`; len(f.filters) != 1 || f.filters[0] != want {
+		t.Errorf("filters = %q, want %q", f.filters, want)
+	}
+
+	close(stop)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("got an event after stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not closed after stop")
+	}
+}
+
+func TestFollowRestarts(t *testing.T) {
+	f := &fakeDocker{}
+	api := newFakeDocker(t, f)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun := &Tunnel{listener: listener, done: make(chan struct{})}
+	go func() {
+		defer close(tun.done)
+		for {
+			if _, err := listener.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	go followRestarts(api, "app_db", tun)
+	var ev dockerEvent
+	ev.Type, ev.Action = "container", "start"
+	f.events <- ev
+
+	select {
+	case <-tun.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener not closed after the container started")
+	}
+	if want := `{"container":["app_db"],"event":["start"],"type":["container"]}`; len(f.filters) != 1 || f.filters[0] != want {
+		t.Errorf("filters = %q, want %q", f.filters, want)
+	}
+}
+
+func TestDemuxDockerStream(t *testing.T) {
+	var in bytes.Buffer
+	mu := &sync.Mutex{}
+	(&frameWriter{&in, dockerStreamStdout, mu}).Write([]byte("out 1\n"))
+	(&frameWriter{&in, dockerStreamStderr, mu}).Write([]byte("warning\n"))
+	(&frameWriter{&in, dockerStreamStdout, mu}).Write([]byte("out 2\n"))
+
+	var stdout, stderr bytes.Buffer
+	if err := demuxDockerStream(&in, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out 1\nout 2\n" || stderr.String() != "warning\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	// A stream cut off inside a frame is an error.
+	if err := demuxDockerStream(bytes.NewReader([]byte{1, 0, 0, 0, 0, 0, 0, 9, 'x'}), io.Discard, io.Discard); err == nil {
+		t.Error("expected an error for a truncated frame")
+	}
+}
+
+func TestInspectDockerContainersAPI(t *testing.T) {
+	db := runningContainer("app_db", "postgres:16", "POSTGRES_PASSWORD_FILE=/run/secrets/pw")
+	db.ID = "aaa"
+	db.NetworkSettings.Networks = map[string]dockerNetwork{"app_default": {IPAddress: "172.20.0.3"}}
+	f := &fakeDocker{
+		containers: []dockerContainer{db},
+		run: func(container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) int {
+			if slices.Equal(cmd, []string{"cat", "--", "/run/secrets/pw"}) {
+				fmt.Fprintln(stdout, "from-secret")
+				return 0
+			}
+			fmt.Fprintf(stderr, "cat: %s: No such file or directory\n", cmd[len(cmd)-1])
+			return 1
+		},
+	}
+	api := newFakeDocker(t, f)
+
+	containers, err := inspectDockerContainers(api, 5*time.Second, compileRules(builtinDiscoveryRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 {
+		t.Fatalf("containers = %+v", containers)
+	}
+	c := containers[0]
+	if c.password != "from-secret" || c.dbUser != "postgres" || c.ip != "172.20.0.3" || len(c.secretErrs) != 0 {
+		t.Errorf("app_db = %+v", c)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// checkStatus is the outcome of a single doctor step.
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case checkPass:
+		return "PASS"
+	case checkWarn:
+		return "WARN"
+	case checkFail:
+		return "FAIL"
+	}
+	return "SKIP"
+}
+
+// doctorCheck is one line of a doctor report.
+type doctorCheck struct {
+	status checkStatus
+	step   string
+	detail string
+}
+
+// doctorReport collects the checks for one section (local tools or a host).
+type doctorReport struct {
+	title  string
+	checks []doctorCheck
+}
+
+func (r *doctorReport) add(status checkStatus, step, format string, a ...any) {
+	r.checks = append(r.checks, doctorCheck{status: status, step: step, detail: fmt.Sprintf(format, a...)})
+}
+
+// failed reports whether any check in the report failed.
+func (r *doctorReport) failed() bool {
+	for _, c := range r.checks {
+		if c.status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// runDoctor implements `drillbit doctor [host...]`: check the local SQL
+// clients, then walk every configured host (or just the named ones)
+// through each discovery step. Returns 1 if any step failed.
+func runDoctor(app *cliApp, args []string) int {
+	fs := app.flagSet("doctor")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if !app.loadConfig() {
+		return exitError
+	}
+
+	hosts := app.cfg.Hosts
+	if fs.NArg() > 0 {
+		hosts = nil
+		for _, name := range fs.Args() {
+			hc := findHostConfig(app.cfg, name)
+			if hc == nil {
+				return usageError(fs, "host %q is not in the config", name)
+			}
+			hosts = append(hosts, *hc)
+		}
+	}
+
+	reports := []*doctorReport{checkLocalTools()}
+	reports = append(reports, make([]*doctorReport, len(hosts))...)
+	var wg sync.WaitGroup
+	for i, hc := range hosts {
+		wg.Add(1)
+		go func(i int, hc HostConfig) {
+			defer wg.Done()
+			reports[i+1] = checkHost(hc)
+		}(i, hc)
+	}
+	wg.Wait()
+
+	code := exitOK
+	for _, r := range reports {
+		writeDoctorReport(app.stdout, r)
+		if r.failed() {
+			code = exitError
+		}
+	}
+	return code
+}
+
+// findHostConfig returns the configured host with the given name, or nil.
+func findHostConfig(cfg *Config, name string) *HostConfig {
+	for i := range cfg.Hosts {
+		if cfg.Hosts[i].Name == name {
+			return &cfg.Hosts[i]
+		}
+	}
+	return nil
+}
+
+// writeDoctorReport prints a report section as aligned status lines.
+func writeDoctorReport(w io.Writer, r *doctorReport) {
+	width := 0
+	for _, c := range r.checks {
+		width = max(width, len(c.step))
+	}
+	fmt.Fprintln(w, r.title)
+	for _, c := range r.checks {
+		fmt.Fprintf(w, "  %-4s  %-*s  %s\n", c.status, width, c.step, c.detail)
+	}
+	fmt.Fprintln(w)
+}
+
+// checkLocalTools looks for the SQL clients the TUI launches on Enter.
+// Having either one is enough.
+func checkLocalTools() *doctorReport {
+	r := &doctorReport{title: "Local tools"}
+	found := false
+	for _, tool := range []string{"pgcli", "psql"} {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			r.add(checkWarn, tool, "not found in PATH")
+			continue
+		}
+		found = true
+		ver, err := toolVersion(path)
+		if err != nil {
+			r.add(checkWarn, tool, "%s (--version failed: %v)", path, err)
+			continue
+		}
+		r.add(checkPass, tool, "%s (%s)", ver, path)
+	}
+	if !found {
+		r.checks[len(r.checks)-1].status = checkFail
+		r.checks[len(r.checks)-1].detail += " — install pgcli or psql to open SQL sessions"
+	}
+	return r
+}
+
+// toolVersion runs `path --version` and returns the first line of output.
+func toolVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return line, nil
+}
+
+// checkHost walks a host through the same steps as dialSSH,
+// newContainerEngine and discoverDockerContainers, recording each one. It
+// stops at the first failure that later steps depend on.
+func checkHost(hc HostConfig) *doctorReport {
+	r := &doctorReport{title: hc.Name}
+	t := resolveSSHTarget(hc.SSHHost())
+	r.add(checkPass, "ssh_config", "hostname=%s user=%s port=%d", t.hostname, t.user, t.port)
+
+	var authMethods []ssh.AuthMethod
+
+	// Agent.
+	switch {
+	case t.agentSocket == "":
+		r.add(checkSkip, "agent", "no IdentityAgent or SSH_AUTH_SOCK")
+	default:
+		conn, err := net.Dial("unix", t.agentSocket)
+		if err != nil {
+			r.add(checkFail, "agent", "%s: %v", t.agentSocket, err)
+			break
+		}
+		defer conn.Close()
+		ag := agent.NewClient(conn)
+		keys, err := ag.List()
+		if err != nil {
+			r.add(checkFail, "agent", "%s: listing keys: %v", t.agentSocket, err)
+			break
+		}
+		r.add(checkPass, "agent", "%s (%d keys)", t.agentSocket, len(keys))
+		authMethods = append(authMethods, ssh.PublicKeysCallback(ag.Signers))
+	}
+
+	// Identity files.
+	if len(t.keyPaths) == 0 {
+		r.add(checkSkip, "identity", "IdentitiesOnly set with no IdentityFile")
+	}
+	for _, kf := range t.keyPaths {
+		signer, err := parseKeyFile(kf)
+		switch {
+		case err == nil:
+			r.add(checkPass, "identity", "%s (%s)", kf, signer.PublicKey().Type())
+			authMethods = append(authMethods, ssh.PublicKeys(signer))
+		case t.defaultKeys && errors.Is(err, os.ErrNotExist):
+			r.add(checkSkip, "identity", "%s: not present", kf)
+		default:
+			var ppErr *ssh.PassphraseMissingError
+			if errors.As(err, &ppErr) {
+				r.add(checkFail, "identity", "%s: passphrase protected — add it to the agent instead", kf)
+			} else {
+				r.add(checkFail, "identity", "%s: %v", kf, err)
+			}
+		}
+	}
+	if len(authMethods) == 0 {
+		r.add(checkFail, "auth", "no SSH auth methods available")
+		return r
+	}
+
+	// TCP.
+	addr := t.addr()
+	tcpConn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		r.add(checkFail, "tcp", "%s: %v", addr, err)
+		return r
+	}
+	r.add(checkPass, "tcp", "%s reachable", addr)
+
+	// Host key and SSH handshake. Unlike hostKeyTOFU, doctor only reports
+	// unknown keys and never writes to known_hosts.
+	hostKey := doctorCheck{status: checkSkip, step: "host key", detail: "handshake ended before key exchange"}
+	cfg := &ssh.ClientConfig{
+		User: t.user,
+		Auth: authMethods,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = checkKnownHost(hostname, remote, key)
+			if hostKey.status == checkFail {
+				return errors.New(hostKey.detail)
+			}
+			return nil
+		},
+	}
+	tcpConn.SetDeadline(time.Now().Add(10 * time.Second))
+	sshConn, chans, reqs, err := ssh.NewClientConn(tcpConn, addr, cfg)
+	r.checks = append(r.checks, hostKey)
+	if err != nil {
+		tcpConn.Close()
+		r.add(checkFail, "handshake", "%v", err)
+		return r
+	}
+	tcpConn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+	r.add(checkPass, "handshake", "authenticated as %s", t.user)
+
+	engine := checkContainerEngine(r, client, hc)
+	if engine == nil {
+		return r
+	}
+
+	// Containers.
+	containers, err := inspectDockerContainers(engine, hc.discoveryTimeout(), hc.discoveryRules())
+	if err != nil {
+		r.add(checkFail, "containers", "%v", err)
+		return r
+	}
+	kept := withPassword(containers)
+	r.add(checkPass, "containers", "%d matched the discovery rules, %d with a password", len(containers), len(kept))
+	for _, c := range containers {
+		for _, se := range c.secretErrs {
+			r.add(checkWarn, "secret", "%s (%s): %s", c.name, c.image, se.msg)
+		}
+		if c.password == "" {
+			if len(c.secretErrs) == 0 {
+				r.add(checkWarn, "dropped", "%s (%s): no %s", c.name, c.image, strings.Join(c.passwordEnv, " or "))
+			}
+			continue
+		}
+		if c.ip == "" {
+			r.add(checkWarn, "container", "%s (%s): no IP address, tunnels can't reach it", c.name, c.image)
+			continue
+		}
+		r.add(checkPass, "container", "%s", containerDetail(c))
+	}
+	return r
+}
+
+// containerDetail describes a discovered container on one line, e.g.
+// "shop-db-1 (postgres) at 172.19.0.2, compose shop/db, published 5432/tcp -> 127.0.0.1:15432".
+func containerDetail(c containerInfo) string {
+	detail := fmt.Sprintf("%s (%s) at %s", c.name, c.image, c.ip)
+	if c.compose != "" {
+		detail += ", compose " + c.compose
+	}
+	if len(c.ports) > 0 {
+		detail += ", published " + strings.Join(c.ports, ", ")
+	}
+	return detail
+}
+
+// checkKnownHost looks key up in ~/.ssh/known_hosts.
+func checkKnownHost(hostname string, remote net.Addr, key ssh.PublicKey) doctorCheck {
+	c := doctorCheck{step: "host key"}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		c.status, c.detail = checkFail, fmt.Sprintf("home dir: %v", err)
+		return c
+	}
+	path := knownHostsPath(home)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.status, c.detail = checkPass, fmt.Sprintf("%s missing — will be created on first connect", path)
+		return c
+	}
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		c.status, c.detail = checkFail, fmt.Sprintf("parsing known_hosts: %v", err)
+		return c
+	}
+
+	err = cb(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		c.status, c.detail = checkPass, fmt.Sprintf("%s found in %s", key.Type(), path)
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		c.status, c.detail = checkFail, fmt.Sprintf("HOST KEY CHANGED for %s — remove the old key from %s", hostname, path)
+	case errors.As(err, &keyErr):
+		c.status, c.detail = checkPass, fmt.Sprintf("%s not in %s — will be trusted on first connect", key.Type(), path)
+	default:
+		c.status, c.detail = checkFail, err.Error()
+	}
+	return c
+}
+
+// checkContainerEngine checks that the host's container engine answers
+// and returns it, or nil if it doesn't. The CLI is tried with and without
+// sudo, as the host's sudo policy allows; one working variant is enough.
+func checkContainerEngine(r *doctorReport, client *ssh.Client, hc HostConfig) containerEngine {
+	if hc.DockerSocket != "" {
+		api := dockerAPIOver(client, hc.DockerSocket)
+		v, err := api.version(hc.commandTimeout())
+		if err != nil {
+			r.add(checkFail, "docker API", "%v", err)
+			return nil
+		}
+		r.add(checkPass, "docker API", "%s: server %s, API %s", hc.DockerSocket, v.Version, v.APIVersion)
+		return api
+	}
+
+	cli := hc.containerCLI()
+	docker := ""
+	switch hc.Sudo {
+	case sudoNever, sudoAlways:
+		withSudo := hc.Sudo == sudoAlways
+		c := checkDockerInfo(client, cli, withSudo, hc.commandTimeout())
+		if c.status == checkPass {
+			docker = sudoPrefix(cli, withSudo)
+		}
+		r.checks = append(r.checks, c)
+	default:
+		plain := checkDockerInfo(client, cli, false, hc.commandTimeout())
+		sudo := checkDockerInfo(client, cli, true, hc.commandTimeout())
+		switch {
+		case plain.status == checkPass:
+			docker = cli
+			if sudo.status == checkFail {
+				sudo.status = checkWarn
+			}
+		case sudo.status == checkPass:
+			docker = sudoPrefix(cli, true)
+			plain.status = checkWarn
+		}
+		r.checks = append(r.checks, plain, sudo)
+	}
+	if docker == "" {
+		return nil
+	}
+	return &dockerCLI{client: client, docker: docker}
+}
+
+// sudoPrefix returns the container CLI as it is run, with sudo in front
+// if withSudo is set.
+func sudoPrefix(cli string, withSudo bool) string {
+	if withSudo {
+		return "sudo " + cli
+	}
+	return cli
+}
+
+// checkDockerInfo runs `info` with the given container CLI, through
+// non-interactive sudo if withSudo is set, and reports the server version
+// (docker only) or the error output.
+func checkDockerInfo(client *ssh.Client, cli string, withSudo bool, timeout time.Duration) doctorCheck {
+	c := doctorCheck{step: sudoPrefix(cli, withSudo) + " info"}
+	docker := cli
+	if withSudo {
+		docker = "sudo -n " + cli
+	}
+	// Only docker itself knows --format '{{.ServerVersion}}'.
+	format := ""
+	if fields := strings.Fields(cli); len(fields) > 0 && filepath.Base(fields[0]) == "docker" {
+		format = ` --format '{{.ServerVersion}}'`
+	}
+	// Always exit 0 so the error output comes back through runSSHCommand.
+	cmd := fmt.Sprintf(`out=$(%s info%s 2>&1) && echo "ok $out" || echo "fail $out"`, docker, format)
+	out, err := runSSHCommand(client, cmd, timeout)
+	if err != nil {
+		c.status, c.detail = checkFail, err.Error()
+		return c
+	}
+	status, msg, _ := strings.Cut(out, " ")
+	msg, _, _ = strings.Cut(strings.TrimSpace(msg), "\n")
+	switch {
+	case status == "ok" && format == "":
+		c.status, c.detail = checkPass, "ok"
+	case status == "ok":
+		c.status, c.detail = checkPass, "server "+msg
+	default:
+		c.status, c.detail = checkFail, msg
+	}
+	return c
+}
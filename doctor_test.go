@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestWriteDoctorReport(t *testing.T) {
+	r := &doctorReport{title: "server1"}
+	r.add(checkPass, "tcp", "10.0.0.1:22 reachable")
+	r.add(checkWarn, "dropped", "db1 (postgres): no POSTGRES_PASSWORD")
+	if r.failed() {
+		t.Error("failed() = true with no FAIL checks")
+	}
+	r.add(checkFail, "handshake", "unable to authenticate")
+	if !r.failed() {
+		t.Error("failed() = false with a FAIL check")
+	}
+
+	var out bytes.Buffer
+	writeDoctorReport(&out, r)
+	want := `server1
+  PASS  tcp        10.0.0.1:22 reachable
+  WARN  dropped    db1 (postgres): no POSTGRES_PASSWORD
+  FAIL  handshake  unable to authenticate
+
+`
+	if out.String() != want {
+		t.Errorf("report =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestCheckKnownHost(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	known, other := newTestHostKey(t), newTestHostKey(t)
+
+	if c := checkKnownHost("server1:22", remote, known); c.status != checkPass || !strings.Contains(c.detail, "missing") {
+		t.Errorf("no known_hosts: %+v", c)
+	}
+
+	path := knownHostsPath(home)
+	os.MkdirAll(filepath.Dir(path), 0o700)
+	line := knownhosts.Line([]string{knownhosts.Normalize("server1:22")}, known)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		key        ssh.PublicKey
+		wantStatus checkStatus
+		wantDetail string
+	}{
+		{"known", "server1:22", known, checkPass, "found"},
+		{"unknown", "server2:22", known, checkPass, "will be trusted"},
+		{"changed", "server1:22", other, checkFail, "HOST KEY CHANGED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := checkKnownHost(tt.host, remote, tt.key)
+			if c.status != tt.wantStatus || !strings.Contains(c.detail, tt.wantDetail) {
+				t.Errorf("got %v %q, want %v containing %q", c.status, c.detail, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestContainerDetail(t *testing.T) {
+	c := containerInfo{name: "app_db", image: "postgres", ip: "172.17.0.2"}
+	if got, want := containerDetail(c), "app_db (postgres) at 172.17.0.2"; got != want {
+		t.Errorf("containerDetail = %q, want %q", got, want)
+	}
+	c.compose = "app/db"
+	c.ports = []string{"5432/tcp -> 127.0.0.1:15432", "5432/tcp -> ::1:15432"}
+	want := "app_db (postgres) at 172.17.0.2, compose app/db, published 5432/tcp -> 127.0.0.1:15432, 5432/tcp -> ::1:15432"
+	if got := containerDetail(c); got != want {
+		t.Errorf("containerDetail = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// containerEngine is how drillbit works with the containers on a host:
+// through the container CLI in ssh sessions (dockerCLI), or through the
+// Docker Engine API on a socket forwarded over ssh (dockerAPI) when the
+// host sets docker_socket.
+type containerEngine interface {
+	// containers inspects the running containers that a rule matches.
+	// The others aren't inspected, so their environments never leave the
+	// host.
+	containers(rules []discoveryRule, timeout time.Duration) ([]dockerContainer, error)
+	// inspect inspects one container by name or ID.
+	inspect(name string, timeout time.Duration) (dockerContainer, error)
+	// exec runs cmd in a container, with stdin attached if it isn't nil
+	// and the output copied to stdout. A timeout of 0 means none. If the
+	// command fails, the error is the first line it wrote to stderr.
+	exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, timeout time.Duration) error
+	// String names the engine for messages, e.g. "sudo docker".
+	String() string
+}
+
+// newContainerEngine returns the engine for a host reached over client.
+func newContainerEngine(client *ssh.Client, rt HostRuntime) containerEngine {
+	if rt.DockerSocket != "" {
+		return dockerAPIOver(client, rt.DockerSocket)
+	}
+	return &dockerCLI{client: client, docker: dockerCmd(client, rt)}
+}
+
+// dockerAPIOver returns an Engine API client for a socket on the host
+// reached over client.
+func dockerAPIOver(client *ssh.Client, socket string) *dockerAPI {
+	return newDockerAPI(socket, func() (net.Conn, error) {
+		return client.Dial("unix", socket)
+	})
+}
+
+// runInContainer runs cmd in a container and returns its trimmed output.
+func runInContainer(e containerEngine, name string, cmd []string, timeout time.Duration) (string, error) {
+	var out bytes.Buffer
+	if err := e.exec(name, cmd, nil, &out, timeout); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// dockerCLI is a containerEngine that runs the container CLI on the host.
+type dockerCLI struct {
+	client *ssh.Client
+	docker string // command prefix, see dockerCmd
+}
+
+func (d *dockerCLI) String() string { return d.docker }
+
+// containers lists the running containers and inspects only those a rule
+// matches, so the environments of the others never leave the host. A
+// container that stops between ps and inspect makes inspect fail, but it
+// still prints the others.
+func (d *dockerCLI) containers(rules []discoveryRule, timeout time.Duration) ([]dockerContainer, error) {
+	deadline := time.Now().Add(timeout)
+	out, err := runSSHCommand(d.client, d.docker+` ps --format '{{.ID}}\t{{.Image}}\t{{.Names}}'`, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("docker ps: %w", err)
+	}
+	ids := matchingContainers(out, rules)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return nil, errors.New("docker inspect: discovery timed out")
+	}
+	script := d.docker + " inspect"
+	for _, id := range ids {
+		script += " " + shellQuote(id)
+	}
+	out, err = runSSHCommand(d.client, script+" 2>/dev/null; true", remaining)
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect: %w", err)
+	}
+	return decodeDockerInspect([]byte(out))
+}
+
+// matchingContainers returns the IDs of the containers in docker ps
+// output, one "ID<tab>image<tab>names" line each, that a rule matches.
+func matchingContainers(ps string, rules []discoveryRule) []string {
+	var ids []string
+	for line := range strings.Lines(ps) {
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if len(fields) != 3 || fields[0] == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(fields[2], ",") // then any link names
+		if ruleFor(rules, fields[1], name) != nil {
+			ids = append(ids, fields[0])
+		}
+	}
+	return ids
+}
+
+func (d *dockerCLI) inspect(name string, timeout time.Duration) (dockerContainer, error) {
+	out, err := runSSHCommand(d.client, d.docker+" inspect "+shellQuote(name), timeout)
+	if err != nil {
+		return dockerContainer{}, err
+	}
+	inspected, err := decodeDockerInspect([]byte(out))
+	if err != nil {
+		return dockerContainer{}, err
+	}
+	if len(inspected) == 0 {
+		return dockerContainer{}, fmt.Errorf("no such container: %s", name)
+	}
+	return inspected[0], nil
+}
+
+func (d *dockerCLI) exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, timeout time.Duration) error {
+	session, err := d.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	defer session.Close()
+
+	stderr := &stderrBuffer{}
+	session.Stdin, session.Stdout, session.Stderr = stdin, stdout, stderr
+	done := make(chan error, 1)
+	go func() { done <- session.Run(execCommand(d.docker, name, cmd, stdin != nil)) }()
+
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timedOut = time.After(timeout)
+	}
+	select {
+	case err := <-done:
+		return execError(err, stderr.String())
+	case <-timedOut:
+		session.Close()
+		return fmt.Errorf("command timed out after %s", timeout)
+	}
+}
+
+// execCommand builds the command line that runs cmd in a container.
+func execCommand(docker, name string, cmd []string, interactive bool) string {
+	var b strings.Builder
+	b.WriteString(docker + " exec ")
+	if interactive {
+		b.WriteString("-i ")
+	}
+	b.WriteString(shellQuote(name))
+	for _, arg := range cmd {
+		b.WriteString(" " + shellQuote(arg))
+	}
+	return b.String()
+}
+
+// execError is the error for a finished command: the first line it wrote
+// to stderr, if any, rather than just its exit status.
+func execError(err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return errors.New(firstLine(msg))
+	}
+	return err
+}
+
+// stderrBuffer keeps the start of a command's stderr, which is where the
+// reason it failed usually is, without growing without bound.
+type stderrBuffer struct {
+	bytes.Buffer
+}
+
+const maxStderr = 4 << 10
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	if room := maxStderr - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExecCommand(t *testing.T) {
+	tests := []struct {
+		docker      string
+		cmd         []string
+		interactive bool
+		want        string
+	}{
+		{"docker", []string{"cat", "--", "/run/secrets/db password"}, false, `docker exec 'app_db' 'cat' '--' '/run/secrets/db password'`},
+		{"sudo podman", []string{"psql", "-c", "SELECT 'x'"}, false, `sudo podman exec 'app_db' 'psql' '-c' 'SELECT '\''x'\'''`},
+		{"docker", []string{"psql", "--quiet"}, true, `docker exec -i 'app_db' 'psql' '--quiet'`},
+	}
+	for _, tt := range tests {
+		if got := execCommand(tt.docker, "app_db", tt.cmd, tt.interactive); got != tt.want {
+			t.Errorf("execCommand(%q, %q) = %s, want %s", tt.docker, tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestMatchingContainers(t *testing.T) {
+	ps := "4f66ad9a0b2e\tpostgres:16-alpine\tshop-db-1\n" +
+		"0b9c8d7e6f5a\tnginx:1.27\tshop-web-1\n" +
+		"9a8b7c6d5e4f\tpostgis/postgis:16-3.4\tgis,app/gis\r\n" +
+		"malformed line\n"
+	got := matchingContainers(ps, compileRules(builtinDiscoveryRules))
+	if want := []string{"4f66ad9a0b2e", "9a8b7c6d5e4f"}; !slices.Equal(got, want) {
+		t.Errorf("matchingContainers = %q, want %q", got, want)
+	}
+}
+
+func TestExecError(t *testing.T) {
+	exit := errors.New("Process exited with status 1")
+	if err := execError(nil, "a warning"); err != nil {
+		t.Errorf("execError for success = %v", err)
+	}
+	if err := execError(exit, "\npg_dump: error: connection failed\ndetail\n"); err == nil || err.Error() != "pg_dump: error: connection failed" {
+		t.Errorf("execError = %v", err)
+	}
+	if err := execError(exit, ""); err != exit {
+		t.Errorf("execError without stderr = %v", err)
+	}
+}
+
+func TestStderrBuffer(t *testing.T) {
+	var b stderrBuffer
+	line := strings.Repeat("x", 1000) + "\n"
+	for range 10 {
+		if n, err := b.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	if b.Len() != maxStderr {
+		t.Errorf("kept %d bytes, want %d", b.Len(), maxStderr)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runExec implements `drillbit exec <host>/<container> -- cmd [args...]`.
+// It discovers the target host, opens a single tunnel on an ephemeral local
+// port, runs cmd with libpq environment variables pointing at the tunnel,
+// and tears the tunnel down when cmd exits. Returns cmd's exit code.
+func runExec(app *cliApp, args []string) int {
+	fs := app.flagSet("exec")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	target, argv, err := parseExecArgs(fs.Args())
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
+	}
+
+	e, err := findTargetEntry(app.cfg, target)
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	tm := NewTunnelManager()
+	defer tm.pool.CloseAll()
+
+	// Port 0: let the OS pick a free port so we never collide with a
+	// running TUI or `drillbit up` that already holds the hashed port.
+	tun, ip, err := tm.setupTunnel(e.SSHHost, e.dockerName(), e.ContainerIP, 0, e.runtime)
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %s/%s: %v\n", e.Host, e.Container, err)
+		return exitError
+	}
+	defer func() {
+		tun.listener.Close()
+		<-tun.done
+		tm.pool.Release(tun.sshHost)
+	}()
+	e.ContainerIP = ip
+	e.LocalPort = uint16(tun.listener.Addr().(*net.TCPAddr).Port)
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), libpqEnv(e)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The child shares our terminal, so Ctrl+C already reaches it directly.
+	// Swallow SIGINT here so we survive long enough to close the tunnel,
+	// and pass SIGTERM on since it is usually aimed at us alone.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return 127
+	}
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		if code, ok := exitStatus(err); ok {
+			return code
+		}
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// exitStatus returns the status a shell would report for a child whose
+// Wait returned err: its exit code, or 128 plus the signal that killed
+// it. ok is false if err isn't about how the child ended.
+func exitStatus(err error) (code int, ok bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	if ws, isWait := exitErr.Sys().(syscall.WaitStatus); isWait && ws.Signaled() {
+		return 128 + int(ws.Signal()), true
+	}
+	if code := exitErr.ExitCode(); code >= 0 {
+		return code, true
+	}
+	return 0, false
+}
+
+// parseExecArgs splits exec arguments into the host/container target and
+// the child command. The "--" separator is optional but recommended.
+func parseExecArgs(args []string) (target string, argv []string, err error) {
+	if len(args) == 0 {
+		return "", nil, errors.New("missing <host>/<container>")
+	}
+	target = args[0]
+	argv = args[1:]
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+	if !strings.Contains(target, "/") {
+		return "", nil, fmt.Errorf("target %q must be <host>/<container>", target)
+	}
+	if len(argv) == 0 {
+		return "", nil, errors.New("missing command to run")
+	}
+	return target, argv, nil
+}
+
+// findTargetEntry discovers the host named in a <host>/<container> target
+// and returns the entry for its container.
+func findTargetEntry(cfg *Config, target string) (*Entry, error) {
+	host, container, _ := strings.Cut(target, "/")
+
+	hc := findHostConfig(cfg, host)
+	if hc == nil {
+		return nil, fmt.Errorf("host %q is not in the config", host)
+	}
+
+	entries, errs := discoverSync([]HostConfig{*hc}, nil, nil)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %v", host, errs[0].err)
+	}
+
+	var names []string
+	for i := range entries {
+		if entries[i].Container == container {
+			if entries[i].Error != "" {
+				return nil, fmt.Errorf("%s: %s", target, entries[i].Error)
+			}
+			return &entries[i], nil
+		}
+		names = append(names, entries[i].Container)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no databases found on %s", host)
+	}
+	return nil, fmt.Errorf("container %q not found on %s (have: %s)", container, host, strings.Join(names, ", "))
+}
+
+// libpqEnv returns the PG* and DATABASE_URL variables for a connected entry.
+func libpqEnv(e *Entry) []string {
+	return []string{
+		"PGHOST=localhost",
+		"PGPORT=" + strconv.Itoa(int(e.LocalPort)),
+		"PGUSER=" + e.DBUser,
+		"PGPASSWORD=" + e.Password,
+		"PGDATABASE=" + e.Database,
+		"DATABASE_URL=" + connString(e),
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HostImport generates hosts from an existing inventory instead of listing
+// them by hand: the concrete Host aliases in ~/.ssh/config, or the ssh://
+// endpoints of local Docker contexts, whose names match a glob.
+type HostImport struct {
+	FromSSHConfig     string `yaml:"from_ssh_config,omitempty"`
+	FromDockerContext string `yaml:"from_docker_context,omitempty"`
+	User              string `yaml:"user,omitempty"`
+	Env               string `yaml:"env,omitempty"`
+	// EnvPattern is a regexp matched against the alias or context name;
+	// its first capture group (or the whole match) becomes the env label.
+	EnvPattern  string           `yaml:"env_pattern,omitempty"`
+	HostRuntime `yaml:",inline"` // applied to every generated host
+}
+
+// sshIncludeDepth matches OpenSSH's limit on nested Include directives.
+const sshIncludeDepth = 5
+
+// importHosts expands import rules into hosts. A host generated by an
+// earlier rule wins over a later one with the same name. Matching entries
+// that can't be imported are returned as warnings.
+func importHosts(imports []HostImport) ([]HostConfig, []string, error) {
+	var hosts []HostConfig
+	var warnings []string
+	for _, imp := range imports {
+		var envRe *regexp.Regexp
+		if imp.EnvPattern != "" {
+			var err error
+			if envRe, err = regexp.Compile(imp.EnvPattern); err != nil {
+				return nil, nil, fmt.Errorf("import_hosts: env_pattern %q: %w", imp.EnvPattern, err)
+			}
+		}
+
+		var found []importedHost
+		switch {
+		case imp.FromSSHConfig != "":
+			home, _ := os.UserHomeDir()
+			for _, alias := range sshConfigAliases(filepath.Join(home, ".ssh", "config"), 0) {
+				if ok, _ := path.Match(imp.FromSSHConfig, alias); ok {
+					found = append(found, importedHost{label: alias, host: HostConfig{Name: alias}})
+				}
+			}
+		case imp.FromDockerContext != "":
+			for _, c := range dockerContextHosts() {
+				if ok, _ := path.Match(imp.FromDockerContext, c.label); ok {
+					found = append(found, c)
+				}
+			}
+		}
+
+		for _, f := range found {
+			if f.skip != "" {
+				warnings = append(warnings, "import_hosts: "+f.skip)
+				continue
+			}
+			if hostIndex(hosts, f.host.Name) >= 0 {
+				continue
+			}
+			h := f.host
+			h.User = firstNonEmpty(imp.User, h.User)
+			h.Env = imp.Env
+			h.HostRuntime = imp.HostRuntime
+			if env := matchEnv(envRe, f.label); env != "" {
+				h.Env = env
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, warnings, nil
+}
+
+// importedHost is a generated host and the name import rules match
+// against: the ssh alias, or the Docker context name.
+type importedHost struct {
+	label string
+	host  HostConfig
+	skip  string // why the host can't be imported, if it can't
+}
+
+func matchEnv(re *regexp.Regexp, name string) string {
+	if re == nil {
+		return ""
+	}
+	m := re.FindStringSubmatch(name)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	}
+	return m[0]
+}
+
+// sshConfigAliases returns the concrete Host aliases in an ssh_config file
+// and the files it includes, in file order. Wildcard and negated patterns
+// are skipped since they don't name a host. A missing file has none.
+func sshConfigAliases(file string, depth int) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var aliases []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// "Keyword value", "Keyword=value" and "Keyword = value" are all valid.
+		keyword, rest := line, ""
+		if i := strings.IndexAny(line, " \t="); i >= 0 {
+			keyword, rest = line[:i], strings.TrimLeft(line[i:], " \t=")
+		}
+		args := strings.Fields(rest)
+
+		switch strings.ToLower(keyword) {
+		case "host":
+			for _, a := range args {
+				a = strings.Trim(a, `"`)
+				if a != "" && !strings.ContainsAny(a, "*?!") {
+					aliases = append(aliases, a)
+				}
+			}
+		case "include":
+			if depth >= sshIncludeDepth {
+				continue
+			}
+			for _, pattern := range args {
+				for _, inc := range sshIncludeMatches(pattern) {
+					aliases = append(aliases, sshConfigAliases(inc, depth+1)...)
+				}
+			}
+		}
+	}
+	return aliases
+}
+
+// sshIncludeMatches resolves an Include argument the way ssh does for the
+// user config: relative paths are under ~/.ssh.
+func sshIncludeMatches(pattern string) []string {
+	home, _ := os.UserHomeDir()
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		pattern = filepath.Join(home, pattern[2:])
+	case !filepath.IsAbs(pattern):
+		pattern = filepath.Join(home, ".ssh", pattern)
+	}
+	matches, _ := filepath.Glob(pattern)
+	sort.Strings(matches)
+	return matches
+}
+
+// dockerContextMeta is the part of a Docker context's meta.json we read.
+type dockerContextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host string
+	}
+}
+
+// dockerContextHosts reads the local Docker context store ($DOCKER_CONFIG
+// or ~/.docker) and returns a host for every context with an ssh://
+// endpoint, sorted by context name. Endpoints on a port other than 22
+// can't be imported, since the port can only come from ~/.ssh/config;
+// they are returned with skip set.
+func dockerContextHosts() []importedHost {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	metas, _ := filepath.Glob(filepath.Join(dir, "contexts", "meta", "*", "meta.json"))
+
+	var hosts []importedHost
+	for _, p := range metas {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var meta dockerContextMeta
+		if json.Unmarshal(data, &meta) != nil || meta.Name == "" {
+			continue
+		}
+		u, err := url.Parse(meta.Endpoints["docker"].Host)
+		if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+			continue
+		}
+		h := importedHost{
+			label: meta.Name,
+			host:  HostConfig{Name: u.Hostname(), User: u.User.Username()},
+		}
+		if port := u.Port(); port != "" && port != "22" {
+			h.skip = fmt.Sprintf("docker context %q uses ssh port %s, which drillbit can only take from ~/.ssh/config; "+
+				"add a Host entry with Port %s there and import it with from_ssh_config", meta.Name, port, port)
+		}
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].label < hosts[j].label })
+	return hosts
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeHome points HOME and DOCKER_CONFIG at a temp dir with an ssh config
+// (including a second file) and two Docker contexts.
+func fakeHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+
+	sshDir := filepath.Join(home, ".ssh")
+	os.MkdirAll(filepath.Join(sshDir, "config.d"), 0o700)
+	os.WriteFile(filepath.Join(sshDir, "config"), []byte(`Host *
+    ServerAliveInterval 30
+
+Host db-prod-1 db-prod-2
+    User deploy
+Host=db-staging-1
+Include config.d/*
+Host web-1 !db-*
+`), 0o600)
+	os.WriteFile(filepath.Join(sshDir, "config.d", "extra"), []byte("Host db-dev-1\n"), 0o600)
+
+	for name, host := range map[string]string{
+		"prod-db":   "ssh://admin@pg.example.com",
+		"odd-port":  "ssh://pg2.example.com:2222",
+		"local-tcp": "tcp://127.0.0.1:2375",
+	} {
+		dir := filepath.Join(home, ".docker", "contexts", "meta", name+"-hash")
+		os.MkdirAll(dir, 0o700)
+		os.WriteFile(filepath.Join(dir, "meta.json"),
+			[]byte(`{"Name":"`+name+`","Endpoints":{"docker":{"Host":"`+host+`"}}}`), 0o600)
+	}
+	return home
+}
+
+func TestImportHosts(t *testing.T) {
+	fakeHome(t)
+
+	hosts, warnings, err := importHosts([]HostImport{
+		{FromSSHConfig: "db-*", EnvPattern: `^db-([a-z]+)-`},
+		{FromDockerContext: "*", Env: "docker"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostConfig{
+		{Name: "db-prod-1", Env: "prod"},
+		{Name: "db-prod-2", Env: "prod"},
+		{Name: "db-staging-1", Env: "staging"},
+		{Name: "db-dev-1", Env: "dev"},
+		{Name: "pg.example.com", User: "admin", Env: "docker"},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("importHosts =\n%+v\nwant\n%+v", hosts, want)
+	}
+	// odd-port matches the glob but can't be imported: say so.
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `docker context "odd-port" uses ssh port 2222`) {
+		t.Errorf("warnings = %q", warnings)
+	}
+	if _, warnings, _ := importHosts([]HostImport{{FromDockerContext: "prod-*"}}); len(warnings) != 0 {
+		t.Errorf("warnings for contexts the glob doesn't match: %q", warnings)
+	}
+}
+
+func TestLoadConfigImports(t *testing.T) {
+	home := fakeHome(t)
+	path := filepath.Join(home, "config.yaml")
+	orig := `version: 1
+import_hosts:
+  - from_ssh_config: "db-prod-*"
+    env: prod
+
+hosts:
+  - name: db-prod-2
+    env: live
+`
+	os.WriteFile(path, []byte(orig), 0o600)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Hosts) != 2 || cfg.Hosts[0].Env != "prod" || cfg.Hosts[1].Env != "live" {
+		t.Fatalf("hosts = %+v", cfg.Hosts)
+	}
+
+	// A change to a generated host is saved, but generated hosts aren't.
+	cfg.Hosts[0].Databases = []DatabaseOverride{{Container: "pg", Auto: true}}
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	want := orig + `  - name: db-prod-1
+    databases:
+      - container: pg
+        auto: true
+`
+	if string(data) != want {
+		t.Errorf("saved config =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestValidateImports(t *testing.T) {
+	_, err := parseConfig("c.yaml", []byte(`import_hosts:
+  - from_ssh_config: "db-*"
+    from_docker_context: "*"
+  - env_pattern: "("
+`), false)
+	for _, want := range []string{
+		"c.yaml:3:26: import has both",
+		"c.yaml:4:5: import needs from_ssh_config",
+		"c.yaml:4:18: env_pattern:",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want %q", err, want)
+		}
+	}
+}
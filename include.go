@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configDirName is the fragment directory next to the main config file.
+// Every *.yaml / *.yml file in it is loaded automatically.
+const configDirName = "config.d"
+
+// fragmentPaths lists the fragment files for a config, lowest precedence
+// first: the include globs in the order written (matches sorted within
+// each glob), then config.d/ in name order. Relative includes are
+// resolved against the config file's directory.
+func fragmentPaths(configPath string, include []string) ([]string, error) {
+	base := filepath.Dir(configPath)
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, pattern := range include {
+		pattern = expandTildePath(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(base, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %w", pattern, err)
+		}
+		// A plain path that doesn't exist is a mistake; an empty glob isn't.
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("include %s: file not found", pattern)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			add(m)
+		}
+	}
+
+	for _, ext := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(base, configDirName, ext))
+		sort.Strings(matches)
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	return paths, nil
+}
+
+// loadFragments loads and merges every fragment for a config. Returns nil
+// if there are none.
+func loadFragments(configPath string, include []string) (*Config, error) {
+	paths, err := fragmentPaths(configPath, include)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+
+	merged := &Config{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", p, err)
+		}
+		frag, err := parseConfig(p, data, true)
+		if err != nil {
+			return nil, err
+		}
+		for i := range frag.Hosts {
+			for j := range frag.Hosts[i].Databases {
+				frag.Hosts[i].Databases[j].passwordShared = true
+			}
+		}
+		merged = mergeConfigs(merged, frag)
+	}
+	return merged, nil
+}
+
+// mergeConfigs overlays over onto base and returns the result. Import
+// rules from both are kept, base's first. Discovery rules from both are
+// kept too, over's first so they are tried first. Hosts are matched by
+// name and databases by container; non-empty fields in over win, and
+// auto wins whenever over sets it explicitly. Hosts keep base's order,
+// with hosts new in over appended.
+func mergeConfigs(base, over *Config) *Config {
+	out := &Config{Version: configVersion, Include: over.Include, BackupDir: base.BackupDir}
+	out.Imports = append(append([]HostImport(nil), base.Imports...), over.Imports...)
+	if over.BackupDir != "" {
+		out.BackupDir = over.BackupDir
+	}
+	out.PortRange = firstNonEmpty(over.PortRange, base.PortRange)
+	out.Discovery = append(append([]DiscoveryRule(nil), over.Discovery...), base.Discovery...)
+	if len(base.EnvPortRanges) > 0 || len(over.EnvPortRanges) > 0 {
+		out.EnvPortRanges = maps.Clone(base.EnvPortRanges)
+		if out.EnvPortRanges == nil {
+			out.EnvPortRanges = make(map[string]string)
+		}
+		maps.Copy(out.EnvPortRanges, over.EnvPortRanges)
+	}
+
+	out.Hosts = append([]HostConfig(nil), base.Hosts...)
+	for _, oh := range over.Hosts {
+		if i := hostIndex(out.Hosts, oh.Name); i >= 0 {
+			out.Hosts[i] = mergeHost(out.Hosts[i], oh)
+		} else {
+			out.Hosts = append(out.Hosts, mergeHost(HostConfig{Name: oh.Name}, oh))
+		}
+	}
+	return out
+}
+
+func mergeHost(base, over HostConfig) HostConfig {
+	out := base
+	out.User = firstNonEmpty(over.User, base.User)
+	out.Env = firstNonEmpty(over.Env, base.Env)
+	out.Docker = firstNonEmpty(over.Docker, base.Docker)
+	out.DockerSocket = firstNonEmpty(over.DockerSocket, base.DockerSocket)
+	out.Sudo = firstNonEmpty(over.Sudo, base.Sudo)
+	out.CommandTimeout = firstNonEmpty(over.CommandTimeout, base.CommandTimeout)
+	out.DiscoveryTimeout = firstNonEmpty(over.DiscoveryTimeout, base.DiscoveryTimeout)
+	out.KeepAlive = firstNonEmpty(over.KeepAlive, base.KeepAlive)
+	out.Discovery = append(append([]DiscoveryRule(nil), over.Discovery...), base.Discovery...)
+	out.Databases = append([]DatabaseOverride(nil), base.Databases...)
+	for _, od := range over.Databases {
+		if ov := out.GetOverride(od.Container); ov != nil {
+			ov.User = firstNonEmpty(od.User, ov.User)
+			if od.Password != "" {
+				ov.Password, ov.passwordShared = od.Password, od.passwordShared
+			}
+			ov.Database = firstNonEmpty(od.Database, ov.Database)
+			ov.Port = firstNonEmpty(od.Port, ov.Port)
+			if od.autoSet || od.Auto {
+				ov.Auto, ov.autoSet = od.Auto, od.autoSet
+			}
+		} else {
+			out.Databases = append(out.Databases, od)
+		}
+	}
+	return out
+}
+
+// personalLayer returns the part of cfg that belongs in the user's own
+// file: everything that differs from the shared fragments, plus whatever
+// the user's file already set. Without fragments that is all of cfg.
+func (cfg *Config) personalLayer() *Config {
+	if cfg.shared == nil {
+		return cfg
+	}
+	own := cfg.own
+	if own == nil {
+		own = &Config{}
+	}
+	out := &Config{Include: own.Include, Imports: own.Imports, BackupDir: own.BackupDir, PortRange: own.PortRange, Discovery: own.Discovery}
+	if cfg.BackupDir != cfg.shared.BackupDir {
+		out.BackupDir = cfg.BackupDir
+	}
+	if cfg.PortRange != cfg.shared.PortRange {
+		out.PortRange = cfg.PortRange
+	}
+	for env, r := range cfg.EnvPortRanges {
+		if _, mine := own.EnvPortRanges[env]; mine || r != cfg.shared.EnvPortRanges[env] {
+			if out.EnvPortRanges == nil {
+				out.EnvPortRanges = make(map[string]string)
+			}
+			out.EnvPortRanges[env] = r
+		}
+	}
+
+	// Hosts already in the user's file keep their place; others follow.
+	for _, h := range orderedHosts(cfg.Hosts, own.Hosts) {
+		si := hostIndex(cfg.shared.Hosts, h.Name)
+		if si < 0 {
+			out.Hosts = append(out.Hosts, h)
+			continue
+		}
+		sh := cfg.shared.Hosts[si]
+		var oh HostConfig
+		oi := hostIndex(own.Hosts, h.Name)
+		if oi >= 0 {
+			oh = own.Hosts[oi]
+		}
+
+		ph := HostConfig{Name: h.Name, Discovery: oh.Discovery}
+		ph.User = personalValue(h.User, sh.User, oh.User)
+		ph.Env = personalValue(h.Env, sh.Env, oh.Env)
+		ph.Docker = personalValue(h.Docker, sh.Docker, oh.Docker)
+		ph.DockerSocket = personalValue(h.DockerSocket, sh.DockerSocket, oh.DockerSocket)
+		ph.Sudo = personalValue(h.Sudo, sh.Sudo, oh.Sudo)
+		ph.CommandTimeout = personalValue(h.CommandTimeout, sh.CommandTimeout, oh.CommandTimeout)
+		ph.DiscoveryTimeout = personalValue(h.DiscoveryTimeout, sh.DiscoveryTimeout, oh.DiscoveryTimeout)
+		ph.KeepAlive = personalValue(h.KeepAlive, sh.KeepAlive, oh.KeepAlive)
+		for _, db := range orderedDatabases(h.Databases, oh.Databases) {
+			sd := sh.GetOverride(db.Container)
+			if sd == nil {
+				ph.Databases = append(ph.Databases, db)
+				continue
+			}
+			od := oh.GetOverride(db.Container)
+			if od == nil {
+				od = &DatabaseOverride{}
+			}
+			pd := DatabaseOverride{
+				Container: db.Container,
+				User:      personalValue(db.User, sd.User, od.User),
+				Password:  personalValue(db.Password, sd.Password, od.Password),
+				Database:  personalValue(db.Database, sd.Database, od.Database),
+				Port:      personalValue(db.Port, sd.Port, od.Port),
+			}
+			if db.Auto != sd.Auto || od.autoSet {
+				pd.Auto, pd.autoSet = db.Auto, true
+			}
+			if pd != (DatabaseOverride{Container: db.Container}) || od.Container != "" {
+				ph.Databases = append(ph.Databases, pd)
+			}
+		}
+
+		if oi >= 0 || ph.User != "" || ph.Env != "" || ph.HostRuntime != (HostRuntime{}) || len(ph.Databases) > 0 {
+			out.Hosts = append(out.Hosts, ph)
+		}
+	}
+	return out
+}
+
+// personalValue returns v if it belongs in the user's file: it differs
+// from the shared value, or the user's file already set it.
+func personalValue[T comparable](v, shared, own T) T {
+	var zero T
+	if v != shared || own != zero {
+		return v
+	}
+	return zero
+}
+
+// orderedHosts returns hosts with those named in first leading, in first's
+// order.
+func orderedHosts(hosts, first []HostConfig) []HostConfig {
+	var out, rest []HostConfig
+	for _, f := range first {
+		if i := hostIndex(hosts, f.Name); i >= 0 {
+			out = append(out, hosts[i])
+		}
+	}
+	for _, h := range hosts {
+		if hostIndex(first, h.Name) < 0 {
+			rest = append(rest, h)
+		}
+	}
+	return append(out, rest...)
+}
+
+// orderedDatabases is orderedHosts for database overrides.
+func orderedDatabases(dbs, first []DatabaseOverride) []DatabaseOverride {
+	var out, rest []DatabaseOverride
+	firstHost := HostConfig{Databases: first}
+	host := HostConfig{Databases: dbs}
+	for _, f := range first {
+		if d := host.GetOverride(f.Container); d != nil {
+			out = append(out, *d)
+		}
+	}
+	for _, d := range dbs {
+		if firstHost.GetOverride(d.Container) == nil {
+			rest = append(rest, d)
+		}
+	}
+	return append(out, rest...)
+}
+
+func hostIndex(hosts []HostConfig, name string) int {
+	for i := range hosts {
+		if hosts[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func firstNonEmpty[T comparable](a, b T) T {
+	var zero T
+	if a != zero {
+		return a
+	}
+	return b
+}
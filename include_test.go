@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigFragments(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	shared := `hosts:
+  - name: server1
+    user: deploy
+    env: prod
+    databases:
+      - container: db1
+        auto: true
+        database: app
+  - name: server2
+    env: test
+`
+	teamD := `hosts:
+  - name: server2
+    env: staging
+  - name: server3
+`
+	writeTestFile(t, filepath.Join(dir, "team", "hosts.yaml"), shared)
+	writeTestFile(t, filepath.Join(dir, configDirName, "10-team.yaml"), teamD)
+	writeTestFile(t, configPath, `version: 1
+include:
+  - team/*.yaml
+# my overrides
+hosts:
+  - name: server1
+    databases:
+      - container: db1
+        auto: false
+        password: mine
+`)
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		if len(cfg.Hosts) != 3 {
+			t.Fatalf("expected 3 hosts, got %+v", cfg.Hosts)
+		}
+		db := cfg.Hosts[0].GetOverride("db1")
+		if cfg.Hosts[0].User != "deploy" || db.Auto || db.Password != "mine" || db.Database != "app" {
+			t.Errorf("server1 = %+v, db1 = %+v", cfg.Hosts[0], db)
+		}
+		// config.d beats include globs.
+		if cfg.Hosts[1].Env != "staging" {
+			t.Errorf("server2 env = %q, want staging", cfg.Hosts[1].Env)
+		}
+	})
+
+	t.Run("save writes only the personal layer", func(t *testing.T) {
+		cfg.Hosts[0].GetOverride("db1").Auto = true
+		cfg.Hosts[2].Databases = append(cfg.Hosts[2].Databases, DatabaseOverride{Container: "db3", Password: "pw3"})
+		if err := SaveConfig(cfg, configPath); err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := os.ReadFile(configPath)
+		want := `version: 1
+include:
+  - team/*.yaml
+# my overrides
+hosts:
+  - name: server1
+    databases:
+      - container: db1
+        auto: true
+        password: mine
+  - name: server3
+    databases:
+      - container: db3
+        password: pw3
+`
+		if string(got) != want {
+			t.Errorf("config.yaml =\n%s\nwant\n%s", got, want)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "team", "hosts.yaml")); string(data) != shared {
+			t.Error("shared fragment was modified")
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, configDirName, "10-team.yaml")); string(data) != teamD {
+			t.Error("config.d fragment was modified")
+		}
+
+		reloaded, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ov := reloaded.Hosts[2].GetOverride("db3"); ov == nil || ov.Password != "pw3" {
+			t.Errorf("server3 after reload = %+v", reloaded.Hosts[2])
+		}
+	})
+}
+
+func TestLoadConfigOnlyFragments(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, configPath, "# hosts come from config.d\n")
+	writeTestFile(t, filepath.Join(dir, configDirName, "hosts.yml"), "hosts:\n  - name: server1\n")
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Hosts[0].Databases = append(cfg.Hosts[0].Databases, DatabaseOverride{Container: "db1", Auto: true})
+	if err := SaveConfig(cfg, configPath); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(configPath)
+	if !strings.HasPrefix(string(got), "# hosts come from config.d\n") || !strings.Contains(string(got), "container: db1") {
+		t.Errorf("config.yaml =\n%s", got)
+	}
+}
+
+func TestLoadConfigMissingInclude(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, configPath, "include: [missing.yaml]\nhosts:\n  - name: server1\n")
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("err = %v, want missing include error", err)
+	}
+
+	// An empty glob is fine.
+	writeTestFile(t, configPath, "include: [team/*.yaml]\nhosts:\n  - name: server1\n")
+	if _, err := LoadConfig(configPath); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfigEnvPortRanges(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, filepath.Join(dir, configDirName, "team.yaml"), `env_port_ranges:
+  prod: 15000-15999
+  test: 25000-25999
+`)
+	own := `version: 1
+env_port_ranges:
+  test: 26000-26999
+hosts:
+  - name: server1
+`
+	writeTestFile(t, configPath, own)
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.localPorts("prod"); got != (portRange{15000, 15999}) {
+		t.Errorf("prod range = %v", got)
+	}
+	if got := cfg.localPorts("test"); got != (portRange{26000, 26999}) {
+		t.Errorf("test range = %v, want the personal one", got)
+	}
+	if got := cfg.localPorts("dev"); got != defaultPortRange {
+		t.Errorf("dev range = %v", got)
+	}
+
+	// The shared ranges stay out of the personal file.
+	if err := SaveConfig(cfg, configPath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != own {
+		t.Errorf("saved config =\n%s", data)
+	}
+}
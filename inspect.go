@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dockerContainer is the part of `docker inspect` output discovery uses.
+// podman inspect produces the same fields.
+type dockerContainer struct {
+	ID              string                `json:"Id"`
+	Name            string                `json:"Name"`
+	State           dockerState           `json:"State"`
+	Config          dockerConfig          `json:"Config"`
+	NetworkSettings dockerNetworkSettings `json:"NetworkSettings"`
+}
+
+type dockerState struct {
+	Status  string `json:"Status"` // running, exited, ...
+	Running bool   `json:"Running"`
+}
+
+type dockerConfig struct {
+	Image  string            `json:"Image"`
+	Env    []string          `json:"Env"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type dockerNetworkSettings struct {
+	IPAddress string                         `json:"IPAddress"` // default bridge network
+	Ports     map[string][]dockerPortBinding `json:"Ports"`     // by "5432/tcp"; nil bindings if not published
+	Networks  map[string]dockerNetwork       `json:"Networks"`
+}
+
+type dockerNetwork struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+type dockerPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// Labels docker compose sets on the containers it creates.
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// name returns the container name without docker's leading slash.
+func (c *dockerContainer) name() string {
+	return strings.TrimPrefix(c.Name, "/")
+}
+
+// ip returns the container's address on its first network, by network
+// name, or on the default bridge.
+func (c *dockerContainer) ip() string {
+	names := make([]string, 0, len(c.NetworkSettings.Networks))
+	for n := range c.NetworkSettings.Networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if ip := c.NetworkSettings.Networks[n].IPAddress; ip != "" {
+			return ip
+		}
+	}
+	return c.NetworkSettings.IPAddress
+}
+
+// publishedPorts lists the container ports bound on the host, sorted.
+func (c *dockerContainer) publishedPorts() []string {
+	var ports []string
+	for port, bindings := range c.NetworkSettings.Ports {
+		for _, b := range bindings {
+			ports = append(ports, fmt.Sprintf("%s -> %s:%s", port, firstNonEmpty(b.HostIP, "0.0.0.0"), b.HostPort))
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// compose returns "project/service" for a container docker compose
+// created, or "".
+func (c *dockerContainer) compose() string {
+	project, service := c.Config.Labels[composeProjectLabel], c.Config.Labels[composeServiceLabel]
+	if project == "" || service == "" {
+		return ""
+	}
+	return project + "/" + service
+}
+
+// decodeDockerInspect decodes the JSON array docker inspect prints.
+func decodeDockerInspect(out []byte) ([]dockerContainer, error) {
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return nil, nil
+	}
+	var inspected []dockerContainer
+	if err := json.Unmarshal(out, &inspected); err != nil {
+		return nil, fmt.Errorf("parsing docker inspect output: %w", err)
+	}
+	return inspected, nil
+}
+
+// parseDockerInspect parses docker inspect output into containerInfo
+// records; see containerInfos.
+func parseDockerInspect(out []byte, rules []discoveryRule) ([]containerInfo, error) {
+	inspected, err := decodeDockerInspect(out)
+	if err != nil {
+		return nil, err
+	}
+	return containerInfos(inspected, rules), nil
+}
+
+// containerInfos turns inspected containers into containerInfo records,
+// for running containers only. Credentials are read from the env vars
+// named by the container's rule. Containers no rule matches, or labeled
+// drillbit.ignore=true, are left out. The drillbit.user and
+// drillbit.database labels beat the env. Credentials kept in *_FILE
+// secrets are left in secrets for readSecretFiles, and the defaults are
+// only applied to containers without any.
+func containerInfos(inspected []dockerContainer, rules []discoveryRule) []containerInfo {
+	var containers []containerInfo
+	for i := range inspected {
+		dc := &inspected[i]
+		name := dc.name()
+		if name == "" || (dc.State.Status != "" && !dc.State.Running) {
+			continue
+		}
+		rule := ruleFor(rules, dc.Config.Image, name)
+		if rule == nil {
+			continue
+		}
+		labels := parseContainerLabels(dc.Config.Labels)
+		if labels.ignore {
+			continue
+		}
+
+		env := parseEnv(dc.Config.Env)
+		info := containerInfo{
+			name:        name,
+			image:       rule.imageType(dc.Config.Image),
+			passwordEnv: rule.PasswordEnv,
+			ip:          dc.ip(),
+			ports:       dc.publishedPorts(),
+			compose:     dc.compose(),
+			labels:      labels,
+		}
+		for _, f := range []struct {
+			field string
+			names []string
+		}{{"user", rule.UserEnv}, {"password", rule.PasswordEnv}, {"database", rule.DatabaseEnv}} {
+			if v := labels.credential(f.field); v != "" {
+				info.set(f.field, v)
+				continue
+			}
+			value, fileEnv, path := lookupEnvOrFile(env, f.names)
+			if fileEnv != "" {
+				info.secrets = append(info.secrets, secretFile{field: f.field, env: fileEnv, path: path})
+				continue
+			}
+			info.set(f.field, value)
+		}
+		if len(info.secrets) == 0 {
+			info.applyDefaults()
+		}
+		containers = append(containers, info)
+	}
+	return containers
+}
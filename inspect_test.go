@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// runningContainer returns a running container as docker inspect would
+// describe it.
+func runningContainer(name, image string, env ...string) dockerContainer {
+	return dockerContainer{
+		Name:   "/" + name,
+		State:  dockerState{Status: "running", Running: true},
+		Config: dockerConfig{Image: image, Env: env},
+	}
+}
+
+// inspectJSON encodes containers the way docker inspect prints them.
+func inspectJSON(t *testing.T, containers ...dockerContainer) []byte {
+	t.Helper()
+	out, err := json.MarshalIndent(containers, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// A trimmed docker inspect of a compose-managed postgres container.
+const composeInspectOutput = `[
+    {
+        "Id": "4f66ad9a0b2e1c0d8a7e3b6f5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d",
+        "Created": "2026-09-30T08:12:44.512Z",
+        "Path": "docker-entrypoint.sh",
+        "Args": ["postgres"],
+        "State": {
+            "Status": "running",
+            "Running": true,
+            "Paused": false,
+            "Pid": 2817
+        },
+        "Image": "sha256:9c1b1a2b3c4d",
+        "Name": "/shop-db-1",
+        "Config": {
+            "Hostname": "4f66ad9a0b2e",
+            "Env": [
+                "POSTGRES_USER=shop",
+                "POSTGRES_PASSWORD=pa=ss",
+                "POSTGRES_DB=shop",
+                "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+                "PGDATA=/var/lib/postgresql/data"
+            ],
+            "Cmd": ["postgres"],
+            "Image": "postgres:16-alpine",
+            "Labels": {
+                "com.docker.compose.project": "shop",
+                "com.docker.compose.service": "db",
+                "com.docker.compose.version": "2.29.1"
+            }
+        },
+        "NetworkSettings": {
+            "IPAddress": "",
+            "Ports": {
+                "5432/tcp": [
+                    {"HostIp": "127.0.0.1", "HostPort": "15432"},
+                    {"HostIp": "::1", "HostPort": "15432"}
+                ]
+            },
+            "Networks": {
+                "shop_default": {
+                    "IPAddress": "172.19.0.2",
+                    "Gateway": "172.19.0.1"
+                }
+            }
+        }
+    },
+    {
+        "Id": "0b9c8d7e6f5a",
+        "State": {"Status": "exited", "Running": false},
+        "Name": "/shop-db-old",
+        "Config": {"Image": "postgres:15", "Env": ["POSTGRES_PASSWORD=old"]},
+        "NetworkSettings": {"Networks": {"bridge": {"IPAddress": ""}}}
+    }
+]`
+
+func TestParseDockerInspect(t *testing.T) {
+	containers, err := parseDockerInspect([]byte(composeInspectOutput), compileRules(builtinDiscoveryRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The exited container is skipped.
+	if len(containers) != 1 {
+		t.Fatalf("containers = %+v, want shop-db-1 only", containers)
+	}
+	c := containers[0]
+	if c.name != "shop-db-1" || c.image != "postgres" || c.dbUser != "shop" || c.password != "pa=ss" || c.database != "shop" {
+		t.Errorf("shop-db-1 = %+v", c)
+	}
+	if c.ip != "172.19.0.2" {
+		t.Errorf("ip = %q, want 172.19.0.2", c.ip)
+	}
+	if want := []string{"5432/tcp -> 127.0.0.1:15432", "5432/tcp -> ::1:15432"}; !slices.Equal(c.ports, want) {
+		t.Errorf("ports = %q, want %q", c.ports, want)
+	}
+	if c.compose != "shop/db" {
+		t.Errorf("compose = %q, want shop/db", c.compose)
+	}
+}
+
+func TestDockerContainerIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings dockerNetworkSettings
+		want     string
+	}{
+		{"default bridge", dockerNetworkSettings{IPAddress: "172.17.0.3"}, "172.17.0.3"},
+		{"one network", dockerNetworkSettings{Networks: map[string]dockerNetwork{"app_default": {IPAddress: "172.20.0.4"}}}, "172.20.0.4"},
+		{"first network by name", dockerNetworkSettings{Networks: map[string]dockerNetwork{
+			"zeta":  {IPAddress: "10.0.2.5"},
+			"alpha": {IPAddress: "10.0.1.5"},
+		}}, "10.0.1.5"},
+		{"skips networks without an address", dockerNetworkSettings{IPAddress: "172.17.0.3", Networks: map[string]dockerNetwork{
+			"alpha":  {},
+			"bridge": {IPAddress: "172.17.0.3"},
+		}}, "172.17.0.3"},
+		{"none", dockerNetworkSettings{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dockerContainer{NetworkSettings: tt.settings}
+			if got := c.ip(); got != tt.want {
+				t.Errorf("ip = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerContainerPublishedPorts(t *testing.T) {
+	c := dockerContainer{NetworkSettings: dockerNetworkSettings{Ports: map[string][]dockerPortBinding{
+		"5432/tcp": {{HostPort: "5433"}},
+		"8008/tcp": nil, // exposed, not published
+	}}}
+	if got, want := c.publishedPorts(), []string{"5432/tcp -> 0.0.0.0:5433"}; !slices.Equal(got, want) {
+		t.Errorf("publishedPorts = %q, want %q", got, want)
+	}
+	if got := (&dockerContainer{}).publishedPorts(); got != nil {
+		t.Errorf("publishedPorts with no ports = %q", got)
+	}
+}
+
+func TestDockerContainerCompose(t *testing.T) {
+	c := dockerContainer{Config: dockerConfig{Labels: map[string]string{composeProjectLabel: "shop"}}}
+	if got := c.compose(); got != "" {
+		t.Errorf("compose without a service = %q", got)
+	}
+	c.Config.Labels[composeServiceLabel] = "db"
+	if got := c.compose(); got != "shop/db" {
+		t.Errorf("compose = %q, want shop/db", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	errLocked          = errors.New("locked by another process")
+	errInstanceRunning = errors.New("another drillbit is running with this config")
+)
+
+// fileLock is an exclusive advisory lock held on an open file. It is
+// released when the process exits, however it exits.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile opens (creating) path and locks it. Without wait it fails with
+// errLocked instead of waiting for another process to let go.
+func lockFile(path string, wait bool) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFD(f, wait); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	err := unlockFD(l.f)
+	l.f.Close()
+	return err
+}
+
+// withFileLock runs fn while holding the lock file path+".lock", so
+// processes sharing a file take turns rewriting it.
+func withFileLock(path string, fn func() error) error {
+	l, err := lockFile(path+".lock", true)
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", filepath.Base(path), err)
+	}
+	defer l.Unlock()
+	return fn()
+}
+
+// instanceInfo describes the process holding the instance lock. It's
+// written into the lock file for a second instance to show.
+type instanceInfo struct {
+	PID     int       `json:"pid"`
+	TTY     string    `json:"tty,omitempty"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (i instanceInfo) String() string {
+	where := "no terminal"
+	if i.TTY != "" {
+		where = i.TTY
+	}
+	return fmt.Sprintf("PID %d on %s, `drillbit %s` started %s", i.PID, where, i.Command, i.Started.Format("Jan 2 15:04"))
+}
+
+// instanceLockPath returns the lock file that marks the process owning
+// the tunnels for a config.
+func instanceLockPath(configPath string) string {
+	return configStatePath(configPath, "drillbit.lock")
+}
+
+// configStatePath returns the path of a state file that belongs to one
+// config, such as its instance lock or port assignments. It lives next to
+// the config. For any config but config.yaml, the name is prefixed with
+// the config's file name, so configs sharing a directory don't share
+// state; config.yaml keeps the plain name it always had.
+func configStatePath(configPath, name string) string {
+	dir, base := filepath.Split(configPath)
+	if base != "config.yaml" {
+		name = base + "." + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// acquireInstance takes the instance lock for configPath on behalf of
+// command (tui or up). If another process holds it, errInstanceRunning is
+// returned with what that process wrote about itself.
+func acquireInstance(configPath, command string) (*fileLock, *instanceInfo, error) {
+	path := instanceLockPath(configPath)
+	l, err := lockFile(path, false)
+	if errors.Is(err, errLocked) {
+		var other instanceInfo
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &other)
+		}
+		return nil, &other, errInstanceRunning
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("instance lock: %w", err)
+	}
+
+	info := instanceInfo{PID: os.Getpid(), TTY: ttyName(), Command: command, Started: time.Now()}
+	data, _ := json.Marshal(info)
+	l.f.Truncate(0)
+	l.f.WriteAt(append(data, '\n'), 0)
+	return l, nil, nil
+}
+
+// ttyName returns the terminal on stdin, or "" if there is none.
+func ttyName() string {
+	cmd := exec.Command("tty")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// confirmReadOnly tells the user where the other instance runs and asks
+// whether to start read-only instead. Without a terminal to ask on, the
+// answer is no.
+func confirmReadOnly(w io.Writer, in io.Reader, isTerminal bool, other *instanceInfo) bool {
+	fmt.Fprintf(w, "drillbit is already running with this config (%s).\n", other)
+	fmt.Fprintln(w, "Switch to that terminal to use it, or start this one read-only: you can browse and copy, but not connect or change the config.")
+	if !isTerminal {
+		fmt.Fprintln(w, "Run `drillbit tui --read-only` to start read-only.")
+		return false
+	}
+	fmt.Fprint(w, "Start read-only? [y/N] ")
+	var answer string
+	fmt.Fscanln(in, &answer)
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireInstance(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	lock, _, err := acquireInstance(configPath, "tui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := acquireInstance(configPath, "up")
+	if !errors.Is(err, errInstanceRunning) {
+		t.Fatalf("second acquire err = %v, want errInstanceRunning", err)
+	}
+	if other.PID != os.Getpid() || other.Command != "tui" {
+		t.Errorf("other instance = %+v", other)
+	}
+
+	lock.Unlock()
+	lock, _, err = acquireInstance(configPath, "up")
+	if err != nil {
+		t.Fatalf("acquire after unlock: %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestInstancePerConfig(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "work.yaml")
+
+	lock, _, err := acquireInstance(a, "tui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	other, _, err := acquireInstance(b, "tui")
+	if err != nil {
+		t.Fatalf("second config in the same directory: %v", err)
+	}
+	other.Unlock()
+
+	for _, f := range []func(string) string{instanceLockPath, controlSocketPath, portStatePath, discoveryCachePath} {
+		if pa, pb := f(a), f(b); pa == pb || filepath.Dir(pa) != dir || filepath.Dir(pb) != dir {
+			t.Errorf("state paths %s and %s", pa, pb)
+		}
+	}
+	if got := instanceLockPath(a); got != filepath.Join(dir, "drillbit.lock") {
+		t.Errorf("config.yaml lock = %s", got)
+	}
+	if got := portStatePath(b); got != filepath.Join(dir, "work.yaml.ports.json") {
+		t.Errorf("work.yaml port state = %s", got)
+	}
+}
+
+func TestConfirmReadOnly(t *testing.T) {
+	other := &instanceInfo{PID: 42, TTY: "/dev/pts/3", Command: "tui"}
+	var out strings.Builder
+	if !confirmReadOnly(&out, strings.NewReader("y\n"), true, other) {
+		t.Error("answer y was not taken as yes")
+	}
+	if !strings.Contains(out.String(), "PID 42 on /dev/pts/3") {
+		t.Errorf("prompt = %q", out.String())
+	}
+	if confirmReadOnly(&out, strings.NewReader("\n"), true, other) {
+		t.Error("empty answer was taken as yes")
+	}
+	if confirmReadOnly(&out, strings.NewReader("y\n"), false, other) {
+		t.Error("started read-only without a terminal")
+	}
+}
+
+func TestSaveConfigWaitsForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	l, err := lockFile(path+".lock", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- SaveConfig(&Config{Hosts: []HostConfig{{Name: "a"}}}, path) }()
+	select {
+	case <-done:
+		t.Fatal("saved while another process held the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	l.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Container labels that describe a database to drillbit, so a compose
+// file can carry what would otherwise be overrides in every config.yaml.
+// Overrides in the config still win.
+const (
+	labelPrefix   = "drillbit."
+	labelName     = "drillbit.name"     // name to list the container under
+	labelEnv      = "drillbit.env"      // env label, instead of the host's
+	labelUser     = "drillbit.user"     // database user
+	labelDatabase = "drillbit.database" // database name
+	labelPort     = "drillbit.port"     // pinned local port
+	labelIgnore   = "drillbit.ignore"   // "true" hides the container
+)
+
+// containerLabels is what a container's drillbit.* labels say. Labels
+// with values that can't be used are left out and reported in problems.
+type containerLabels struct {
+	name     string
+	env      string
+	user     string
+	database string
+	port     uint16
+	ignore   bool
+	problems []string
+}
+
+// parseContainerLabels reads the drillbit.* labels of a container. Other
+// labels and unknown drillbit.* keys are ignored.
+func parseContainerLabels(labels map[string]string) containerLabels {
+	var l containerLabels
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, labelPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := strings.TrimSpace(labels[key])
+		switch key {
+		case labelName:
+			if value == "" || strings.ContainsAny(value, " \t/:") {
+				l.problem(key, value, "must be a name without spaces, '/' or ':'")
+				continue
+			}
+			l.name = value
+		case labelEnv:
+			if !envLabelPattern.MatchString(value) {
+				l.problem(key, value, "must not contain tabs, line breaks or other control characters")
+				continue
+			}
+			l.env = value
+		case labelUser:
+			l.user = value
+		case labelDatabase:
+			l.database = value
+		case labelPort:
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				l.problem(key, value, "must be a port number between 1 and 65535")
+				continue
+			}
+			l.port = uint16(port)
+		case labelIgnore:
+			ignore, err := strconv.ParseBool(value)
+			if err != nil {
+				l.problem(key, value, "must be true or false")
+				continue
+			}
+			l.ignore = ignore
+		}
+	}
+	return l
+}
+
+func (l *containerLabels) problem(key, value, msg string) {
+	l.problems = append(l.problems, fmt.Sprintf("label %s=%q %s", key, value, msg))
+}
+
+// credential returns the value a label gives for a credential field.
+func (l containerLabels) credential(field string) string {
+	switch field {
+	case "user":
+		return l.user
+	case "database":
+		return l.database
+	}
+	return ""
+}
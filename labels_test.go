@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseContainerLabels(t *testing.T) {
+	l := parseContainerLabels(map[string]string{
+		"com.docker.compose.service": "db",
+		"drillbit.name":              "orders",
+		"drillbit.env":               "staging",
+		"drillbit.user":              "app",
+		"drillbit.database":          "orders_prod",
+		"drillbit.port":              "15432",
+		"drillbit.ignore":            "false",
+		"drillbit.unknown":           "x",
+	})
+	want := containerLabels{name: "orders", env: "staging", user: "app", database: "orders_prod", port: 15432}
+	if l.name != want.name || l.env != want.env || l.user != want.user || l.database != want.database || l.port != want.port || l.ignore || len(l.problems) != 0 {
+		t.Errorf("labels = %+v, want %+v", l, want)
+	}
+
+	bad := parseContainerLabels(map[string]string{
+		"drillbit.name":   "a/b",
+		"drillbit.env":    "prod\twest",
+		"drillbit.port":   "99999",
+		"drillbit.ignore": "maybe",
+	})
+	if bad.name != "" || bad.env != "" || bad.port != 0 || bad.ignore {
+		t.Errorf("bad labels were used: %+v", bad)
+	}
+	wantProblems := []string{"drillbit.env", "drillbit.ignore", "drillbit.name", "drillbit.port"}
+	if len(bad.problems) != len(wantProblems) {
+		t.Fatalf("problems = %q", bad.problems)
+	}
+	for i, key := range wantProblems {
+		if !strings.Contains(bad.problems[i], key) {
+			t.Errorf("problems[%d] = %q, want one about %s", i, bad.problems[i], key)
+		}
+	}
+}
+
+func TestParseDockerInspectLabels(t *testing.T) {
+	app := runningContainer("app_db_1", "postgres:16", "POSTGRES_USER=postgres", "POSTGRES_PASSWORD=pw", "POSTGRES_DB=app")
+	app.Config.Labels = map[string]string{
+		"com.docker.compose.project": "app",
+		"drillbit.name":              "app",
+		"drillbit.user":              "readonly",
+		"drillbit.database":          "app_reporting",
+	}
+	scratch := runningContainer("scratch_db", "postgres:16", "POSTGRES_PASSWORD=pw")
+	scratch.Config.Labels = map[string]string{"drillbit.ignore": "true"}
+	containers, err := parseDockerContainers(inspectJSON(t, app, scratch), compileRules(builtinDiscoveryRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 {
+		t.Fatalf("containers = %+v, want app_db_1 only", containers)
+	}
+	c := containers[0]
+	if c.name != "app_db_1" || c.labels.name != "app" || c.dbUser != "readonly" || c.database != "app_reporting" || c.password != "pw" {
+		t.Errorf("app_db_1 = %+v", c)
+	}
+}
+
+func TestEntryDockerName(t *testing.T) {
+	e := Entry{Container: "app"}
+	if got := e.dockerName(); got != "app" {
+		t.Errorf("dockerName = %q", got)
+	}
+	e.docker = "app_db_1"
+	if got := e.dockerName(); got != "app_db_1" {
+		t.Errorf("renamed dockerName = %q", got)
+	}
+}
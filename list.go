@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// listRecord is the scriptable view of a discovered Entry.
+type listRecord struct {
+	Env       string `json:"env"`
+	Host      string `json:"host"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	User      string `json:"user"`
+	Password  string `json:"password,omitempty"`
+	Database  string `json:"database"`
+	Port      uint16 `json:"port"`
+	Error     string `json:"error,omitempty"` // e.g. an unresolvable password reference
+}
+
+// listHostError reports a host that failed discovery.
+type listHostError struct {
+	Host  string `json:"host"`
+	Error string `json:"error"`
+}
+
+// listOutput is the top-level JSON document printed by `drillbit list`.
+type listOutput struct {
+	Entries []listRecord    `json:"entries"`
+	Errors  []listHostError `json:"errors,omitempty"`
+}
+
+// runList implements `drillbit list`: discover every host and print the
+// inventory. Returns 1 if any host failed so pipelines can depend on it.
+func runList(app *cliApp, args []string) int {
+	fs := app.flagSet("list")
+	format := fs.String("format", "table", "output format: table, tsv or json")
+	showPasswords := fs.Bool("show-passwords", false, "include passwords in the output")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	switch *format {
+	case "table", "tsv", "json":
+	default:
+		return usageError(fs, "unknown format %q (want table, tsv or json)", *format)
+	}
+
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
+	}
+	entries, errs := discoverSync(app.cfg.Hosts, loadPortState(app.cfg, app.configPath), func(l logEntry) {
+		if l.tag == "WARN" {
+			fmt.Fprintf(app.stderr, "Warning: %s\n", l.text)
+		}
+	})
+	saveDiscoveryCache(app.configPath, entries)
+	if err := writeList(app.stdout, app.stderr, *format, entries, errs, *showPasswords); err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	if len(errs) > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// writeList renders entries in the requested format. Host errors are part
+// of the JSON document; for table and TSV output they go to errw so stdout
+// stays machine-readable.
+func writeList(w, errw io.Writer, format string, entries []Entry, errs []hostError, showPasswords bool) error {
+	out := listOutput{Entries: make([]listRecord, 0, len(entries))}
+	for _, e := range entries {
+		rec := listRecord{
+			Env:       e.Env,
+			Host:      e.Host,
+			Container: e.Container,
+			Image:     e.Image,
+			User:      e.DBUser,
+			Database:  e.Database,
+			Port:      e.LocalPort,
+			Error:     e.Error,
+		}
+		if showPasswords {
+			rec.Password = e.Password
+		}
+		out.Entries = append(out.Entries, rec)
+	}
+	for _, he := range errs {
+		out.Errors = append(out.Errors, listHostError{Host: he.host, Error: he.err.Error()})
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	header := []string{"ENV", "HOST", "CONTAINER", "IMAGE", "USER", "DATABASE", "PORT"}
+	if showPasswords {
+		header = append(header, "PASSWORD")
+	}
+	rows := [][]string{header}
+	for _, r := range out.Entries {
+		row := []string{r.Env, r.Host, r.Container, r.Image, r.User, r.Database, strconv.Itoa(int(r.Port))}
+		if showPasswords {
+			row = append(row, r.Password)
+		}
+		rows = append(rows, row)
+	}
+
+	var err error
+	if format == "tsv" {
+		for _, row := range rows {
+			if _, err = fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		err = tw.Flush()
+	}
+
+	for _, r := range out.Entries {
+		if r.Error != "" {
+			fmt.Fprintf(errw, "error: %s/%s: %s\n", r.Host, r.Container, r.Error)
+		}
+	}
+	for _, he := range out.Errors {
+		fmt.Fprintf(errw, "error: %s: %s\n", he.Host, he.Error)
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteList(t *testing.T) {
+	entries := []Entry{
+		{Env: "prod", Host: "server1", Container: "db1", Image: "postgres", DBUser: "admin", Password: "secret", Database: "app", LocalPort: 12345},
+		{Host: "server2", Container: "db2", Image: "postgis", DBUser: "postgres", Password: "pw2", Database: "geo", LocalPort: 23456},
+	}
+	errs := []hostError{{host: "server3", err: errors.New("ssh dial failed")}}
+
+	t.Run("json", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		if err := writeList(&out, &errOut, "json", entries, errs, false); err != nil {
+			t.Fatal(err)
+		}
+		var doc listOutput
+		if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(doc.Entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(doc.Entries))
+		}
+		if doc.Entries[0].Port != 12345 || doc.Entries[0].User != "admin" {
+			t.Errorf("entries[0] = %+v", doc.Entries[0])
+		}
+		if len(doc.Errors) != 1 || doc.Errors[0].Host != "server3" {
+			t.Errorf("errors = %+v, want server3", doc.Errors)
+		}
+		if strings.Contains(out.String(), "secret") {
+			t.Error("password leaked without --show-passwords")
+		}
+		if errOut.Len() != 0 {
+			t.Errorf("JSON mode should not write to stderr, got %q", errOut.String())
+		}
+	})
+
+	t.Run("json with passwords", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		if err := writeList(&out, &errOut, "json", entries, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), `"password": "secret"`) {
+			t.Errorf("expected password in output, got %s", out.String())
+		}
+	})
+
+	t.Run("tsv", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		if err := writeList(&out, &errOut, "tsv", entries, errs, false); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("expected header + 2 rows, got %d lines", len(lines))
+		}
+		want := "prod\tserver1\tdb1\tpostgres\tadmin\tapp\t12345"
+		if lines[1] != want {
+			t.Errorf("row = %q, want %q", lines[1], want)
+		}
+		if !strings.Contains(errOut.String(), "server3") {
+			t.Errorf("expected host error on stderr, got %q", errOut.String())
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		if err := writeList(&out, &errOut, "table", entries, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if !strings.HasPrefix(lines[0], "ENV") || !strings.HasSuffix(lines[0], "PASSWORD") {
+			t.Errorf("header = %q", lines[0])
+		}
+		// Columns are aligned: CONTAINER starts at the same offset on every row.
+		col := strings.Index(lines[0], "CONTAINER")
+		if strings.Index(lines[1], "db1") != col || strings.Index(lines[2], "db2") != col {
+			t.Errorf("columns not aligned:\n%s", out.String())
+		}
+	})
+}
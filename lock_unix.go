@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFD takes an exclusive advisory lock on f. Without wait it fails
+// with errLocked if another process holds the lock.
+func lockFD(f *os.File, wait bool) error {
+	how := unix.LOCK_EX
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+	err := unix.Flock(int(f.Fd()), how)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFD(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
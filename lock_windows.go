@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the byte range locked: far past the end of the file, since
+// Windows locks are mandatory and the file's contents must stay readable.
+func lockRange() *windows.Overlapped {
+	return &windows.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+}
+
+// lockFD takes an exclusive lock on f. Without wait it fails with
+// errLocked if another process holds the lock.
+func lockFD(f *os.File, wait bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, lockRange())
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFD(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, lockRange())
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 
 	tea "charm.land/bubbletea/v2"
+	"golang.org/x/term"
 )
 
 // Build info, set at build time via -ldflags.
@@ -16,71 +18,130 @@ var (
 )
 
 func main() {
-	configPath := DefaultConfigPath()
-	editMode := false
-
-	args := os.Args[1:]
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--version", "-v":
-			fmt.Printf("drillbit %s (commit: %s, built: %s)\n", version, commit, buildDate)
-			os.Exit(0)
-		case "--help", "-h":
-			printUsage()
-			os.Exit(0)
-		case "-e", "--edit":
-			editMode = true
-		case "-c", "--config":
-			if i+1 >= len(args) {
-				fmt.Fprintln(os.Stderr, "Error: --config requires a path argument")
-				os.Exit(1)
-			}
-			i++
-			configPath = args[i]
-		default:
-			fmt.Fprintf(os.Stderr, "Error: unknown option %q\n\n", args[i])
-			printUsage()
-			os.Exit(1)
-		}
-	}
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	if editMode {
-		openConfigInEditor(configPath)
-		os.Exit(0)
+// runTUI implements the default `drillbit tui` command.
+func runTUI(app *cliApp, args []string) int {
+	fs := app.flagSet("tui")
+	readOnly := fs.Bool("read-only", false, "start read-only if another drillbit is running with this config")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected argument %q", fs.Arg(0))
 	}
+	configPath := app.configPath
 
-	// First run: scaffold config if it doesn't exist.
+	// First run: scaffold config if it doesn't exist. Profiles are created
+	// explicitly, so a typo in --profile doesn't make a new one.
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if app.profile != "" {
+			app.loadConfig() // reports the missing profile
+			return exitError
+		}
 		if err := ScaffoldConfig(configPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating config: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(app.stderr, "Error creating config: %v\n", err)
+			return exitError
 		}
-		fmt.Println()
-		fmt.Println("  \U0001f529 DrillBit — first run!")
-		fmt.Println()
-		fmt.Printf("  Created config file: %s\n", configPath)
-		fmt.Println("  Edit it to add your SSH hosts, then run drillbit again.")
-		fmt.Println()
-		os.Exit(0)
+		fmt.Fprintln(app.stdout)
+		fmt.Fprintln(app.stdout, "  \U0001f529 DrillBit — first run!")
+		fmt.Fprintln(app.stdout)
+		fmt.Fprintf(app.stdout, "  Created config file: %s\n", configPath)
+		fmt.Fprintln(app.stdout, "  Edit it to add your SSH hosts, then run drillbit again.")
+		fmt.Fprintln(app.stdout)
+		return exitOK
 	}
 
-	cfg, err := LoadConfig(configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if !app.loadConfig() || !app.unlockVault() {
+		return exitError
 	}
 
-	m := newModel(cfg, configPath)
+	// Only one instance per config binds ports and writes state.
+	lock, other, err := acquireInstance(configPath, "tui")
+	switch {
+	case errors.Is(err, errInstanceRunning):
+		if !*readOnly && !confirmReadOnly(app.stderr, os.Stdin, term.IsTerminal(int(os.Stdin.Fd())), other) {
+			return exitError
+		}
+	case err != nil:
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	default:
+		defer lock.Unlock()
+	}
+
+	m := newModel(app.cfg, configPath)
 	m.discovering = true
+	m.profile = app.profile
+	m.readOnly = lock == nil
 
 	p := tea.NewProgram(m)
-	if _, err := p.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+
+	// Control socket is best-effort: the TUI works fine without it. A
+	// read-only instance leaves it to the one that owns the tunnels.
+	var ctl *controlServer
+	if !m.readOnly {
+		ctl, _ = startControlServer(controlSocketPath(configPath), p.Send)
+	}
+
+	_, err = p.Run()
+	if ctl != nil {
+		ctl.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runConfigCmd implements `drillbit config <path|edit|validate>`.
+func runConfigCmd(app *cliApp, args []string) int {
+	fs := app.flagSet("config")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	switch fs.Arg(0) {
+	case "path":
+		fmt.Fprintln(app.stdout, app.configPath)
+		return exitOK
+	case "edit":
+		return openConfigInEditor(app.configPath)
+	case "validate":
+		return validateConfigFile(app)
+	case "":
+		return usageError(fs, "missing config subcommand")
+	}
+	return usageError(fs, "unknown config subcommand %q", fs.Arg(0))
+}
+
+// validateConfigFile implements `drillbit config validate`: load the
+// config and report every problem found, one per line.
+func validateConfigFile(app *cliApp) int {
+	cfg, err := LoadConfig(app.configPath)
+	if err != nil {
+		var errs ConfigErrors
+		if errors.As(err, &errs) {
+			fmt.Fprintln(app.stderr, errs)
+			fmt.Fprintf(app.stderr, "%d problem(s) found\n", len(errs))
+		} else {
+			fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		}
+		return exitError
+	}
+	for _, w := range cfg.warnings {
+		fmt.Fprintf(app.stderr, "Warning: %s\n", w)
 	}
+	overrides := 0
+	for _, h := range cfg.Hosts {
+		overrides += len(h.Databases)
+	}
+	fmt.Fprintf(app.stdout, "%s: OK (%d hosts, %d database overrides)\n", app.configPath, len(cfg.Hosts), overrides)
+	return exitOK
 }
 
-func openConfigInEditor(path string) {
+func openConfigInEditor(path string) int {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
 		editor = os.Getenv("VISUAL")
@@ -93,7 +154,7 @@ func openConfigInEditor(path string) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		if err := ScaffoldConfig(path); err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating config: %v\n", err)
-			os.Exit(1)
+			return exitError
 		}
 	}
 
@@ -103,16 +164,7 @@ func openConfigInEditor(path string) {
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening editor: %v\n", err)
-		os.Exit(1)
+		return exitError
 	}
-}
-
-func printUsage() {
-	fmt.Println("Usage: drillbit [options]")
-	fmt.Println()
-	fmt.Println("Options:")
-	fmt.Println("  -c, --config <path>   Config file (default: ~/.config/drillbit/config.yaml)")
-	fmt.Println("  -e, --edit            Open config in $EDITOR")
-	fmt.Println("  -v, --version         Show version")
-	fmt.Println("  -h, --help            Show this help")
+	return exitOK
 }
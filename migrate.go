@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// configVersion is the config schema version this build reads and
+// writes. Files without a version field predate versioning and count as
+// version 0.
+const configVersion = 1
+
+// configMigration upgrades a config document to version to from the
+// version before it, in place. Migrations work on the raw YAML before it
+// is validated, so they can rename or reshape keys the current schema
+// would reject.
+type configMigration struct {
+	to    int
+	apply func(doc *yaml.Node) error
+}
+
+// configMigrations are run in order, one per schema version. Adding a
+// version means appending a migration here and bumping configVersion.
+var configMigrations = []configMigration{
+	// 1 adds the version field itself; nothing else changed.
+	{to: 1, apply: func(*yaml.Node) error { return nil }},
+}
+
+// checkVersion reads the version field of a config document. A version
+// newer than configVersion is reported: this build can't know what its
+// keys mean.
+func (v *configValidator) checkVersion(doc *yaml.Node) int {
+	n := mappingValue(doc, "version")
+	if n == nil || n.Tag == "!!null" {
+		return 0
+	}
+	ver, err := strconv.Atoi(n.Value)
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || err != nil || ver < 0 {
+		v.errorf(n, "version must be a whole number")
+		return 0
+	}
+	if ver > configVersion {
+		v.errorf(n, "config version %d was written by a newer drillbit; this one reads up to version %d (run `drillbit update`)", ver, configVersion)
+	}
+	return ver
+}
+
+// migrateConfig upgrades doc from version from to configVersion and sets
+// its version field.
+func migrateConfig(doc *yaml.Node, from int) error {
+	for _, m := range configMigrations {
+		if m.to <= from {
+			continue
+		}
+		if err := m.apply(doc); err != nil {
+			return fmt.Errorf("upgrading config to version %d: %w", m.to, err)
+		}
+	}
+	setVersion(doc, configVersion)
+	return nil
+}
+
+// setVersion sets the version field of doc, adding it as the first key
+// if it's missing. A comment above the old first key stays at the top.
+func setVersion(doc *yaml.Node, ver int) {
+	value := strconv.Itoa(ver)
+	if n := mappingValue(doc, "version"); n != nil {
+		n.Kind, n.Tag, n.Value, n.Style = yaml.ScalarNode, "!!int", value, 0
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	if len(doc.Content) > 0 {
+		key.HeadComment, doc.Content[0].HeadComment = doc.Content[0].HeadComment, ""
+	}
+	val := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value}
+	doc.Content = append([]*yaml.Node{key, val}, doc.Content...)
+}
+
+// upgradeConfigFile returns the config file data orig in the current
+// schema, ready for SaveConfig to merge into. An older file is first
+// copied to a timestamped backup next to it; comments and layout carry
+// over to the upgraded form. A file from a newer drillbit is refused
+// rather than overwritten.
+func upgradeConfigFile(path string, orig []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(orig, &root); err != nil || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return orig, nil // mergeYAML starts over
+	}
+	doc := root.Content[0]
+	v := &configValidator{path: path}
+	from := v.checkVersion(doc)
+	if len(v.errs) > 0 {
+		return nil, fmt.Errorf("not overwriting %s: %w", path, v.errs)
+	}
+	if from == configVersion {
+		return orig, nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, from, time.Now().Format("20060102-150405"))
+	if err := writeFileAtomic(backup, orig, 0o600); err != nil {
+		return nil, fmt.Errorf("backing up config before upgrade: %w", err)
+	}
+	if err := migrateConfig(doc, from); err != nil {
+		return nil, err
+	}
+	out, err := encodeYAML(&root, detectIndent(doc))
+	if err != nil {
+		return nil, err
+	}
+	return restoreLayout(orig, &root, out), nil
+}
@@ -1,43 +1,324 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"net"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 )
 
+// The default range local ports are assigned from.
 const (
 	portRangeMin = 10000
 	portRangeMax = 65535
-	portRange    = portRangeMax - portRangeMin + 1
 )
 
-// hashPort computes a deterministic port for a host:container pair.
-func hashPort(host, container string) uint16 {
+// portRange is an inclusive range of local ports, written "10000-65535"
+// in the config.
+type portRange struct {
+	min, max uint16
+}
+
+var defaultPortRange = portRange{portRangeMin, portRangeMax}
+
+func parsePortRange(s string) (portRange, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	first, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	last, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if !ok || err1 != nil || err2 != nil || first == 0 || first > last {
+		return portRange{}, fmt.Errorf("%q is not a port range such as 10000-65535", s)
+	}
+	return portRange{uint16(first), uint16(last)}, nil
+}
+
+func (r portRange) String() string {
+	return fmt.Sprintf("%d-%d", r.min, r.max)
+}
+
+func (r portRange) contains(port uint16) bool {
+	return port >= r.min && port <= r.max
+}
+
+func (r portRange) overlaps(o portRange) bool {
+	return r.min <= o.max && o.min <= r.max
+}
+
+func (r portRange) size() int {
+	return int(r.max) - int(r.min) + 1
+}
+
+// find returns the first port ok accepts, starting at start and wrapping
+// around to the bottom of the range.
+func (r portRange) find(start uint16, ok func(uint16) bool) (uint16, bool) {
+	port := start
+	for range r.size() {
+		if ok(port) {
+			return port, true
+		}
+		if port >= r.max {
+			port = r.min
+		} else {
+			port++
+		}
+	}
+	return 0, false
+}
+
+// hashPort computes a deterministic port in r for a host:container pair.
+func hashPort(r portRange, host, container string) uint16 {
 	h := fnv.New32a()
 	fmt.Fprintf(h, "%s:%s", host, container)
-	return uint16(portRangeMin + int(h.Sum32())%portRange)
+	return r.min + uint16(h.Sum32()%uint32(r.size()))
+}
+
+// portState records the port each host:container was assigned, so later
+// runs hand out the same ports even as containers come and go. It is kept
+// in ports.json next to the config, which only the instance holding the
+// instance lock writes.
+type portState struct {
+	Ports map[string]uint16 `json:"ports"` // by tunnelKey
+	// Discoveries counts the discoveries ports were assigned after, and
+	// Seen holds the count at which each tunnelKey was last seen, so
+	// assignments of databases that are gone can be pruned.
+	Discoveries int            `json:"discoveries,omitempty"`
+	Seen        map[string]int `json:"seen,omitempty"`
+
+	path      string
+	rng       portRange              // for envs without a range of their own
+	envRanges map[string]portRange   // by env label
+	bound     func(port uint16) bool // reports a port already taken on this machine
+}
+
+// portPruneAfter is how many discoveries of its host a database can be
+// missing from before its assignment is dropped and the port is free for
+// others again.
+const portPruneAfter = 20
+
+func portStatePath(configPath string) string {
+	return configStatePath(configPath, "ports.json")
+}
+
+// loadPortState reads the saved port assignments for configPath. A missing
+// or unreadable file starts over with none.
+func loadPortState(cfg *Config, configPath string) *portState {
+	st := &portState{path: portStatePath(configPath), rng: cfg.localPorts(""), bound: portBound}
+	for env := range cfg.EnvPortRanges {
+		if st.envRanges == nil {
+			st.envRanges = make(map[string]portRange)
+		}
+		st.envRanges[env] = cfg.localPorts(env)
+	}
+	if data, err := os.ReadFile(st.path); err == nil {
+		json.Unmarshal(data, st)
+	}
+	if st.Ports == nil {
+		st.Ports = make(map[string]uint16)
+	}
+	return st
+}
+
+// prune drops the assignments of databases missing from the last
+// portPruneAfter discoveries of hosts that were found, so one that is gone
+// for good doesn't keep its port forever. Databases on hosts that are
+// down, or have no databases at the moment, keep theirs.
+func (st *portState) prune(found map[string]bool) {
+	for key := range st.Ports {
+		host, _, _ := strings.Cut(key, ":")
+		seen, ok := st.Seen[key]
+		if !ok {
+			// Recorded before drillbit kept track: start counting now.
+			st.Seen[key] = st.Discoveries
+			continue
+		}
+		if found[host] && st.Discoveries-seen >= portPruneAfter {
+			delete(st.Ports, key)
+			delete(st.Seen, key)
+		}
+	}
+}
+
+// rangeFor returns the range ports for env are assigned from.
+func (st *portState) rangeFor(env string) portRange {
+	if r, ok := st.envRanges[env]; ok {
+		return r
+	}
+	return st.rng
+}
+
+// describe names env's range for messages.
+func (st *portState) describe(env string) string {
+	if _, ok := st.envRanges[env]; ok {
+		return fmt.Sprintf("%s for %s", st.rangeFor(env), env)
+	}
+	return st.rng.String()
 }
 
-// AssignPorts assigns unique deterministic local ports to a list of entries.
-// Entries are sorted by host:container for deterministic collision resolution.
-func AssignPorts(entries []Entry) {
+// allowed reports whether port may be assigned to an entry in env. Ports
+// in an env's own range are kept for that env.
+func (st *portState) allowed(env string, port uint16) bool {
+	if !st.rangeFor(env).contains(port) {
+		return false
+	}
+	if _, ok := st.envRanges[env]; ok {
+		return true
+	}
+	for _, r := range st.envRanges {
+		if r.contains(port) {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes ports.json under a lock on it. Only the instance holding
+// the instance lock calls it; commands that just read assignments, and
+// read-only instances, never do.
+func (st *portState) save() error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = withFileLock(st.path, func() error {
+		return writeFileAtomic(st.path, append(data, '\n'), 0o600)
+	})
+	if err != nil {
+		return fmt.Errorf("saving port assignments: %w", err)
+	}
+	return nil
+}
+
+// portBound reports whether something on this machine already listens on
+// the loopback port tunnels would use.
+func portBound(port uint16) bool {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return true
+	}
+	ln.Close()
+	return false
+}
+
+// portWarning is an entry that didn't get the port it asked for: a pin
+// that couldn't be honored, or a full range.
+type portWarning struct {
+	Host, Container string
+	Msg             string
+}
+
+func (w portWarning) String() string {
+	return fmt.Sprintf("%s/%s: %s", w.Host, w.Container, w.Msg)
+}
+
+// AssignPorts assigns unique local ports to a list of entries and records
+// them in st. Each entry's port comes from its env's range (see
+// portState.rangeFor). Pinned ports are reserved first, then the ports
+// entries had in earlier runs. The rest get a port hashed from
+// host:container, moved past ports that are taken, saved for an entry not
+// seen this run, or bound locally; a saved port is only reused for someone
+// else once the range has nothing else left. Entries are sorted by
+// host:container for deterministic collision resolution.
+//
+// A pin that is out of range or already taken by an earlier entry is
+// reported and the entry gets a port as if it weren't pinned. An entry
+// that finds its range full gets no port and an error, and is reported
+// too. A nil st uses the default range and no saved ports. Assignments of
+// databases not seen for a while are pruned (see portState.prune).
+func AssignPorts(entries []Entry, st *portState) []portWarning {
+	if st == nil {
+		st = &portState{rng: defaultPortRange}
+	}
+	if st.Ports == nil {
+		st.Ports = make(map[string]uint16)
+	}
+	if st.Seen == nil {
+		st.Seen = make(map[string]int)
+	}
+	st.Discoveries++
+	found := make(map[string]bool)
+	for i := range entries {
+		st.Seen[tunnelKey(&entries[i])] = st.Discoveries
+		found[entries[i].Host] = true
+	}
+	st.prune(found)
 	sort.Slice(entries, func(i, j int) bool {
-		ki := entries[i].Host + ":" + entries[i].Container
-		kj := entries[j].Host + ":" + entries[j].Container
-		return ki < kj
+		return tunnelKey(&entries[i]) < tunnelKey(&entries[j])
 	})
 
-	used := make(map[uint16]bool)
+	used := make(map[uint16]string)
+	assigned := make([]bool, len(entries))
+	var warnings []portWarning
 	for i := range entries {
-		port := hashPort(entries[i].Host, entries[i].Container)
-		for used[port] {
-			port++
-			if port > portRangeMax {
-				port = portRangeMin
-			}
+		e := &entries[i]
+		port := e.PinnedPort
+		if port == 0 {
+			continue
+		}
+		reason := ""
+		switch owner, taken := used[port]; {
+		case !st.rangeFor(e.Env).contains(port):
+			reason = "is outside the allowed range " + st.describe(e.Env)
+		case taken:
+			reason = "is already pinned by " + owner
+		}
+		if reason != "" {
+			msg := fmt.Sprintf("pinned port %d %s", port, reason)
+			warnings = append(warnings, portWarning{Host: e.Host, Container: e.Container, Msg: msg})
+			continue
+		}
+		used[port] = e.Host + "/" + e.Container
+		e.LocalPort = port
+		assigned[i] = true
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		port, ok := st.Ports[tunnelKey(e)]
+		if assigned[i] || !ok || !st.allowed(e.Env, port) || used[port] != "" {
+			continue
+		}
+		used[port] = e.Host + "/" + e.Container
+		e.LocalPort = port
+		assigned[i] = true
+	}
+
+	saved := make(map[uint16]bool, len(st.Ports))
+	for _, port := range st.Ports {
+		saved[port] = true
+	}
+	for i := range entries {
+		if assigned[i] {
+			continue
+		}
+		e := &entries[i]
+		free := func(port uint16) bool {
+			return used[port] == "" && st.allowed(e.Env, port) && (st.bound == nil || !st.bound(port))
+		}
+		r := st.rangeFor(e.Env)
+		start := hashPort(r, e.Host, e.Container)
+		port, ok := r.find(start, func(port uint16) bool { return !saved[port] && free(port) })
+		if !ok {
+			port, ok = r.find(start, free)
+		}
+		if !ok {
+			msg := "no free local port left in " + st.describe(e.Env)
+			e.LocalPort = 0
+			e.Status, e.Error = StatusError, msg
+			warnings = append(warnings, portWarning{Host: e.Host, Container: e.Container, Msg: msg})
+			continue
+		}
+		used[port] = e.Host + "/" + e.Container
+		e.LocalPort = port
+		assigned[i] = true
+	}
+
+	for i := range entries {
+		if assigned[i] {
+			st.Ports[tunnelKey(&entries[i])] = entries[i].LocalPort
 		}
-		used[port] = true
-		entries[i].LocalPort = port
 	}
+	return warnings
 }
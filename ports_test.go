@@ -1,13 +1,16 @@
 package main
 
 import (
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
 func TestHashPort(t *testing.T) {
 	// Deterministic: same input always gives same output.
-	port1 := hashPort("server1", "db1")
-	port2 := hashPort("server1", "db1")
+	port1 := hashPort(defaultPortRange, "server1", "db1")
+	port2 := hashPort(defaultPortRange, "server1", "db1")
 	if port1 != port2 {
 		t.Errorf("hashPort is not deterministic: %d != %d", port1, port2)
 	}
@@ -18,7 +21,7 @@ func TestHashPort(t *testing.T) {
 	}
 
 	// Different inputs give different ports (usually).
-	port3 := hashPort("server1", "db2")
+	port3 := hashPort(defaultPortRange, "server1", "db2")
 	if port1 == port3 {
 		t.Log("warning: hash collision between server1/db1 and server1/db2 (unlikely but possible)")
 	}
@@ -36,8 +39,8 @@ func TestAssignPorts(t *testing.T) {
 			{Host: "server1", Container: "db2"},
 			{Host: "server2", Container: "db1"},
 		}
-		AssignPorts(entries1)
-		AssignPorts(entries2)
+		AssignPorts(entries1, nil)
+		AssignPorts(entries2, nil)
 
 		for i := range entries1 {
 			if entries1[i].LocalPort != entries2[i].LocalPort {
@@ -54,7 +57,7 @@ func TestAssignPorts(t *testing.T) {
 			{Host: "server2", Container: "db2"},
 			{Host: "server3", Container: "db1"},
 		}
-		AssignPorts(entries)
+		AssignPorts(entries, nil)
 
 		seen := make(map[uint16]bool)
 		for _, e := range entries {
@@ -71,8 +74,8 @@ func TestAssignPorts(t *testing.T) {
 
 	t.Run("empty", func(t *testing.T) {
 		// Should not panic.
-		AssignPorts(nil)
-		AssignPorts([]Entry{})
+		AssignPorts(nil, nil)
+		AssignPorts([]Entry{}, nil)
 	})
 
 	t.Run("order independent", func(t *testing.T) {
@@ -84,8 +87,8 @@ func TestAssignPorts(t *testing.T) {
 			{Host: "server2", Container: "db2"},
 			{Host: "server1", Container: "db1"},
 		}
-		AssignPorts(entries1)
-		AssignPorts(entries2)
+		AssignPorts(entries1, nil)
+		AssignPorts(entries2, nil)
 
 		// After sorting, same host:container should have same port.
 		ports1 := make(map[string]uint16)
@@ -100,3 +103,200 @@ func TestAssignPorts(t *testing.T) {
 		}
 	})
 }
+
+func TestAssignPortsPinned(t *testing.T) {
+	entries := []Entry{
+		{Host: "server2", Container: "db1", PinnedPort: 15432},
+		{Host: "server1", Container: "db1", PinnedPort: 15432},
+		{Host: "server1", Container: "db2", PinnedPort: 5432},
+		{Host: "server3", Container: "db1"},
+	}
+	conflicts := AssignPorts(entries, nil)
+
+	ports := make(map[string]uint16)
+	for _, e := range entries {
+		ports[e.Host+"/"+e.Container] = e.LocalPort
+	}
+	if ports["server1/db1"] != 15432 {
+		t.Errorf("server1/db1 got %d, want its pin 15432", ports["server1/db1"])
+	}
+	for _, key := range []string{"server2/db1", "server1/db2"} {
+		host, container, _ := strings.Cut(key, "/")
+		if ports[key] != hashPort(defaultPortRange, host, container) {
+			t.Errorf("%s got %d, want hashed port %d", key, ports[key], hashPort(defaultPortRange, host, container))
+		}
+	}
+
+	want := []string{
+		"server1/db2: pinned port 5432 is outside the allowed range 10000-65535",
+		"server2/db1: pinned port 15432 is already pinned by server1/db1",
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("conflicts = %v", conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != want[i] {
+			t.Errorf("conflict %d = %q, want %q", i, c, want[i])
+		}
+	}
+}
+
+func TestAssignPortsAvoidsPins(t *testing.T) {
+	// A pin on another entry's hashed port pushes that entry along.
+	hashed := hashPort(defaultPortRange, "server1", "db1")
+	entries := []Entry{
+		{Host: "server1", Container: "db1"},
+		{Host: "server9", Container: "db9", PinnedPort: hashed},
+	}
+	if conflicts := AssignPorts(entries, nil); len(conflicts) != 0 {
+		t.Fatalf("conflicts = %v", conflicts)
+	}
+	if entries[1].LocalPort != hashed || entries[0].LocalPort == hashed {
+		t.Errorf("ports = %d, %d; pin %d", entries[0].LocalPort, entries[1].LocalPort, hashed)
+	}
+}
+
+func TestAssignPortsState(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := &Config{PortRange: "20000-20003"}
+	run := func(bound func(uint16) bool, keys ...string) map[string]Entry {
+		t.Helper()
+		var entries []Entry
+		for _, k := range keys {
+			host, container, _ := strings.Cut(k, ":")
+			entries = append(entries, Entry{Host: host, Container: container})
+		}
+		st := loadPortState(cfg, configPath)
+		st.bound = bound
+		AssignPorts(entries, st)
+		if err := st.save(); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]Entry)
+		for _, e := range entries {
+			got[tunnelKey(&e)] = e
+		}
+		return got
+	}
+
+	// Four databases in a four-port range, one of which is bound.
+	first := run(func(port uint16) bool { return port == 20001 }, "a:db", "b:db", "c:db", "d:db")
+	for _, k := range []string{"a:db", "b:db", "c:db"} {
+		if p := first[k].LocalPort; p == 20001 || !cfg.localPorts("").contains(p) {
+			t.Errorf("%s got port %d", k, p)
+		}
+	}
+	if d := first["d:db"]; d.LocalPort != 0 || d.Error != "no free local port left in 20000-20003" {
+		t.Errorf("d:db = port %d, error %q", d.LocalPort, d.Error)
+	}
+
+	// a:db going away doesn't move the others, and a new database doesn't
+	// take its port while another is free.
+	second := run(nil, "0:new", "b:db", "c:db")
+	for _, k := range []string{"b:db", "c:db"} {
+		if second[k].LocalPort != first[k].LocalPort {
+			t.Errorf("%s moved from %d to %d", k, first[k].LocalPort, second[k].LocalPort)
+		}
+	}
+	if p := second["0:new"].LocalPort; p != 20001 {
+		t.Errorf("0:new got %d, want the free port 20001", p)
+	}
+
+	// Once the range has nothing else, a saved port is handed on.
+	third := run(nil, "0:new", "1:new", "b:db", "c:db")
+	if p := third["1:new"].LocalPort; p != first["a:db"].LocalPort {
+		t.Errorf("1:new got %d, want a:db's old port %d", p, first["a:db"].LocalPort)
+	}
+}
+
+func TestAssignPortsPrune(t *testing.T) {
+	st := &portState{rng: portRange{20000, 20009}, Ports: map[string]uint16{"a:old": 20005, "b:db": 20006}}
+	for range portPruneAfter {
+		AssignPorts([]Entry{{Host: "a", Container: "db"}}, st)
+	}
+	// Recorded before Seen existed: counted from the first discovery.
+	if _, ok := st.Ports["a:old"]; !ok {
+		t.Fatal("a:old pruned too early")
+	}
+	AssignPorts([]Entry{{Host: "a", Container: "db"}}, st)
+	if _, ok := st.Ports["a:old"]; ok {
+		t.Errorf("a:old kept after %d discoveries without it", portPruneAfter)
+	}
+	if _, ok := st.Ports["a:db"]; !ok {
+		t.Error("a:db pruned while seen")
+	}
+	// Host b was never found, e.g. because it is down.
+	if _, ok := st.Ports["b:db"]; !ok {
+		t.Error("b:db pruned while its host is unreachable")
+	}
+}
+
+func TestPortStateSave(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	st := loadPortState(&Config{}, configPath)
+	AssignPorts([]Entry{{Host: "a", Container: "db"}}, st)
+	if err := st.save(); err != nil {
+		t.Fatal(err)
+	}
+	again := loadPortState(&Config{}, configPath)
+	if again.Ports["a:db"] != st.Ports["a:db"] || again.Seen["a:db"] != 1 || again.Discoveries != 1 {
+		t.Errorf("reloaded state = %+v", again)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if r, err := parsePortRange("15000-15999"); err != nil || r != (portRange{15000, 15999}) {
+		t.Errorf("parsePortRange = %v, %v", r, err)
+	}
+	for _, bad := range []string{"", "15000", "0-10", "2000-1000", "1-70000", "a-b"} {
+		if _, err := parsePortRange(bad); err == nil {
+			t.Errorf("parsePortRange(%q) accepted", bad)
+		}
+	}
+}
+
+func TestAssignPortsEnvRanges(t *testing.T) {
+	cfg := &Config{EnvPortRanges: map[string]string{"prod": "15000-15001", "test": "25000-25999"}}
+	st := loadPortState(cfg, filepath.Join(t.TempDir(), "config.yaml"))
+	st.bound = nil
+	entries := []Entry{
+		{Env: "prod", Host: "p1", Container: "db"},
+		{Env: "prod", Host: "p2", Container: "db"},
+		{Env: "prod", Host: "p3", Container: "db"},
+		{Env: "test", Host: "t1", Container: "db", PinnedPort: 15000},
+		{Env: "dev", Host: "d1", Container: "db"},
+	}
+	warnings := AssignPorts(entries, st)
+
+	got := make(map[string]Entry)
+	for _, e := range entries {
+		got[e.Host] = e
+	}
+	for _, h := range []string{"p1", "p2"} {
+		if p := got[h].LocalPort; p < 15000 || p > 15001 {
+			t.Errorf("%s got %d, want a prod port", h, p)
+		}
+	}
+	if p := got["t1"].LocalPort; p < 25000 || p > 25999 {
+		t.Errorf("t1 got %d, want a test port", p)
+	}
+	if p := got["d1"].LocalPort; p < portRangeMin || (p >= 15000 && p <= 15001) || (p >= 25000 && p <= 25999) {
+		t.Errorf("d1 got %d, want a default port outside the env ranges", p)
+	}
+
+	want := []string{
+		"p3/db: no free local port left in 15000-15001 for prod",
+		"t1/db: pinned port 15000 is outside the allowed range 25000-25999 for test",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v", warnings)
+	}
+	for _, w := range warnings {
+		if !slices.Contains(want, w.String()) {
+			t.Errorf("unexpected warning %q", w)
+		}
+	}
+	if got["p3"].Status != StatusError || got["p3"].LocalPort != 0 {
+		t.Errorf("p3 = %+v, want an error and no port", got["p3"])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// defaultProfile names the main config at DefaultConfigPath.
+const defaultProfile = "default"
+
+// profileNamePattern matches profile names: they become directory names
+// and show up in the TUI header.
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,31}$`)
+
+// profilesDir holds one directory per named profile. Everything DrillBit
+// keeps next to a config (fragments, backups, port state, discovery cache,
+// vault, control socket) lives in that directory too, so profiles share
+// nothing.
+func profilesDir() string {
+	return filepath.Join(filepath.Dir(DefaultConfigPath()), "profiles")
+}
+
+// profileConfigPath returns the config file of a profile.
+func profileConfigPath(name string) string {
+	if name == defaultProfile {
+		return DefaultConfigPath()
+	}
+	return filepath.Join(profilesDir(), name, "config.yaml")
+}
+
+func checkProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("profile name %q must be 1-32 letters, digits, '.', '-' or '_'", name)
+	}
+	return nil
+}
+
+// listProfiles returns the names of the profiles that have a config,
+// sorted.
+func listProfiles() []string {
+	dirs, _ := os.ReadDir(profilesDir())
+	var names []string
+	for _, d := range dirs {
+		if !d.IsDir() || !profileNamePattern.MatchString(d.Name()) {
+			continue
+		}
+		if _, err := os.Stat(profileConfigPath(d.Name())); err == nil {
+			names = append(names, d.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runProfile implements `drillbit profile <list|create>`.
+func runProfile(app *cliApp, args []string) int {
+	fs := app.flagSet("profile")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	switch fs.Arg(0) {
+	case "list":
+		if fs.NArg() > 1 {
+			return usageError(fs, "unexpected argument %q", fs.Arg(1))
+		}
+		active := firstNonEmpty(app.profile, defaultProfile)
+		for _, name := range append([]string{defaultProfile}, listProfiles()...) {
+			mark := " "
+			if name == active {
+				mark = "*"
+			}
+			fmt.Fprintf(app.stdout, "%s %-12s %s\n", mark, name, profileConfigPath(name))
+		}
+		return exitOK
+	case "create":
+		if fs.NArg() != 2 {
+			return usageError(fs, "expected one profile name")
+		}
+		return createProfile(app, fs.Arg(1))
+	case "":
+		return usageError(fs, "missing profile subcommand")
+	}
+	return usageError(fs, "unknown profile subcommand %q", fs.Arg(0))
+}
+
+// createProfile scaffolds the config of a new profile.
+func createProfile(app *cliApp, name string) int {
+	if err := checkProfileName(name); err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	path := profileConfigPath(name)
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(app.stderr, "Error: profile %q already exists (%s)\n", name, path)
+		return exitError
+	}
+	if err := ScaffoldConfig(path); err != nil {
+		fmt.Fprintf(app.stderr, "Error creating config: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(app.stdout, "Created profile %q: %s\n", name, path)
+	fmt.Fprintf(app.stdout, "Edit it with: drillbit --profile %s config edit\n", name)
+	return exitOK
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DRILLBIT_PROFILE", "")
+
+	code, out, errOut := runCLITest("profile", "create", "clientA")
+	want := filepath.Join(home, ".config", "drillbit", "profiles", "clientA", "config.yaml")
+	if code != exitOK || !strings.Contains(out, want) {
+		t.Fatalf("create: code=%d out=%q stderr=%q", code, out, errOut)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := runCLITest("profile", "create", "clientA"); code != exitError || !strings.Contains(errOut, "already exists") {
+		t.Errorf("create twice: code=%d stderr=%q", code, errOut)
+	}
+	if code, _, _ := runCLITest("profile", "create", "../escape"); code != exitUsage {
+		t.Errorf("bad name: code=%d", code)
+	}
+
+	code, out, _ = runCLITest("--profile", "clientA", "profile", "list")
+	if code != exitOK || !strings.Contains(out, "  default ") || !strings.Contains(out, "* clientA ") {
+		t.Errorf("list: code=%d out=%q", code, out)
+	}
+
+	t.Setenv("DRILLBIT_PROFILE", "clientA")
+	if code, out, _ := runCLITest("config", "path"); code != exitOK || out != want+"\n" {
+		t.Errorf("config path: code=%d out=%q", code, out)
+	}
+	if code, out, _ := runCLITest("-c", "/tmp/x.yaml", "config", "path"); code != exitOK || out != "/tmp/x.yaml\n" {
+		t.Errorf("--config over $DRILLBIT_PROFILE: code=%d out=%q", code, out)
+	}
+	if code, _, errOut := runCLITest("-c", "/tmp/x.yaml", "--profile", "clientA", "config", "path"); code != exitUsage || !strings.Contains(errOut, "can't be used together") {
+		t.Errorf("--config with --profile: code=%d stderr=%q", code, errOut)
+	}
+
+	code, _, errOut = runCLITest("--profile", "clientB", "list")
+	if code != exitError || !strings.Contains(errOut, `profile "clientB" does not exist`) {
+		t.Errorf("missing profile: code=%d stderr=%q", code, errOut)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// configWatchInterval is how often the TUI checks the config file and its
+// fragments for outside changes.
+const configWatchInterval = 2 * time.Second
+
+// configWatchMsg triggers a config change check.
+type configWatchMsg struct{}
+
+// configStamp fingerprints the config file and every fragment it pulls in
+// by name, size and modification time. Any edit, or a fragment appearing
+// or disappearing, changes the stamp.
+func configStamp(configPath string, cfg *Config) string {
+	files := []string{configPath}
+	if paths, err := fragmentPaths(configPath, cfg.Include); err == nil {
+		files = append(files, paths...)
+	}
+	var b strings.Builder
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", f, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s missing\n", f)
+		}
+	}
+	return b.String()
+}
+
+// hostDiff is what changed between two host lists, by host name.
+type hostDiff struct {
+	added, changed, removed []string
+}
+
+// String summarizes the diff for the status flash.
+func (d hostDiff) String() string {
+	var parts []string
+	for _, p := range []struct {
+		n    int
+		verb string
+	}{{len(d.added), "added"}, {len(d.changed), "changed"}, {len(d.removed), "removed"}} {
+		if p.n == 1 {
+			parts = append(parts, fmt.Sprintf("1 host %s", p.verb))
+		} else if p.n > 1 {
+			parts = append(parts, fmt.Sprintf("%d hosts %s", p.n, p.verb))
+		}
+	}
+	if len(parts) == 0 {
+		return "no host changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// diffHosts compares host lists. A host counts as changed when any of its
+// settings or database overrides differ.
+func diffHosts(old, new []HostConfig) hostDiff {
+	var d hostDiff
+	for _, h := range new {
+		switch i := hostIndex(old, h.Name); {
+		case i < 0:
+			d.added = append(d.added, h.Name)
+		case !reflect.DeepEqual(old[i], h):
+			d.changed = append(d.changed, h.Name)
+		}
+	}
+	for _, h := range old {
+		if hostIndex(new, h.Name) < 0 {
+			d.removed = append(d.removed, h.Name)
+		}
+	}
+	return d
+}
+
+func (m *Model) scheduleConfigWatch() tea.Cmd {
+	return tea.Tick(configWatchInterval, func(time.Time) tea.Msg {
+		return configWatchMsg{}
+	})
+}
+
+// checkConfigChange reloads the config if it changed on disk since the
+// last check. It waits while a discovery is running or an overlay that
+// holds on to an entry is open; the change is picked up on the first tick
+// after that.
+func (m *Model) checkConfigChange() []tea.Cmd {
+	switch m.mode {
+	case modeEdit, modeBackup, modeRestore, modeConfirmRestore, modeRestoring, modeQuitting:
+		return nil
+	}
+	if m.discovering {
+		return nil
+	}
+	stamp := configStamp(m.configPath, m.cfg)
+	if stamp == m.seenStamp {
+		return nil
+	}
+	m.seenStamp = stamp
+	return m.reloadConfig()
+}
+
+// reloadConfig loads the config from disk, swaps it in and rediscovers
+// the hosts that were added or changed. Entries on unchanged hosts, and
+// their tunnels, are left alone; tunnels on removed hosts are closed. If
+// the file doesn't load, the current config stays and saves are refused
+// until it is fixed.
+func (m *Model) reloadConfig() []tea.Cmd {
+	var cmds []tea.Cmd
+	cfg, err := LoadConfig(m.configPath)
+	if err != nil {
+		m.flash = errorMsgStyle.Render(fmt.Sprintf("Config reload failed: %v", firstLine(err.Error())))
+		return append(cmds, m.clearFlashAfter(5*time.Second))
+	}
+
+	diff := diffHosts(m.cfg.Hosts, cfg.Hosts)
+	m.cfg = cfg
+	m.seenStamp = cfg.stamp
+
+	if len(diff.removed) > 0 {
+		var kept []Entry
+		for i := range m.entries {
+			e := &m.entries[i]
+			if !slices.Contains(diff.removed, e.Host) {
+				kept = append(kept, *e)
+				continue
+			}
+			if e.Status == StatusConnected || e.Status == StatusConnecting {
+				cmds = append(cmds, m.tunnels.Disconnect(e))
+			}
+		}
+		m.entries = kept
+		m.applyFilter()
+	}
+
+	m.flash = flashStyle.Render("Config reloaded — " + diff.String())
+	cmds = append(cmds, m.clearFlashAfter(3*time.Second))
+
+	if hosts := append(diff.added, diff.changed...); len(hosts) > 0 {
+		cmds = append(cmds, m.rescan(hosts)...)
+	}
+	return cmds
+}
+
+// rescan rediscovers the named hosts, or queues them for when the
+// running discovery is done, which may have used their old settings.
+func (m *Model) rescan(hosts []string) []tea.Cmd {
+	if m.discovering {
+		for _, h := range hosts {
+			if !slices.Contains(m.queuedRescan, h) {
+				m.queuedRescan = append(m.queuedRescan, h)
+			}
+		}
+		return nil
+	}
+	return m.startDiscovery(hosts)
+}
+
+// saveConfig writes m.cfg unless the file changed on disk since it was
+// loaded or last saved. On a conflict nothing is written: the file is
+// reloaded instead, dropping the in-memory change, and errConfigChanged
+// is returned so the caller can say so.
+func (m *Model) saveConfig() ([]tea.Cmd, error) {
+	err := SaveConfig(m.cfg, m.configPath)
+	if errors.Is(err, errConfigChanged) {
+		return m.reloadConfig(), err
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.seenStamp = m.cfg.stamp
+	return nil, nil
+}
+
+// saveConfigFlash applies edit to m.cfg and saves it, setting the flash
+// to ok on success, or to the save error or conflict message. If the file
+// changed on disk meanwhile, saveConfig reloads it and edit is applied
+// again on top, so neither the outside change nor the user's is lost.
+// edit sets values rather than toggling them, so applying it twice is
+// harmless. If the save fails, the edit is taken back so the TUI doesn't
+// show, or later save, what isn't on disk. Reports whether the save
+// happened.
+func (m *Model) saveConfigFlash(ok string, edit func()) ([]tea.Cmd, bool) {
+	undo := []func(){m.editConfig(edit)}
+	cmds, err := m.saveConfig()
+	merged := false
+	if errors.Is(err, errConfigChanged) {
+		undo = append(undo, m.editConfig(edit))
+		more, retryErr := m.saveConfig()
+		cmds, err, merged = append(cmds, more...), retryErr, true
+	}
+	if err != nil {
+		for _, u := range slices.Backward(undo) {
+			u()
+		}
+	}
+	switch {
+	case errors.Is(err, errConfigChanged):
+		m.flash = errorMsgStyle.Render(fmt.Sprintf("Conflict: %s keeps changing or doesn't load — your change was NOT saved. Fix the file and try again.", filepath.Base(m.configPath)))
+		return append(cmds, m.clearFlashAfter(6*time.Second)), false
+	case err != nil:
+		m.flash = errorMsgStyle.Render(fmt.Sprintf("Save: %v", err))
+		return append(cmds, m.clearFlashAfter(2*time.Second)), false
+	}
+	m.flash = ok
+	if merged {
+		m.flash += flashStyle.Render(fmt.Sprintf(" (on top of changes made to %s outside drillbit)", filepath.Base(m.configPath)))
+	}
+	return append(cmds, m.clearFlashAfter(2*time.Second)), true
+}
+
+// editConfig applies edit to m.cfg and returns a func that takes it back
+// again, unless m.cfg was replaced by a reload in between. Edits only
+// touch hosts and their database overrides, so those are what is kept.
+func (m *Model) editConfig(edit func()) (undo func()) {
+	cfg, hosts := m.cfg, cloneHosts(m.cfg.Hosts)
+	edit()
+	return func() {
+		if m.cfg == cfg {
+			cfg.Hosts = hosts
+		}
+	}
+}
+
+// cloneHosts copies hosts deep enough that editing an override in the
+// copy leaves the original alone.
+func cloneHosts(hosts []HostConfig) []HostConfig {
+	out := slices.Clone(hosts)
+	for i := range out {
+		out[i].Databases = slices.Clone(out[i].Databases)
+	}
+	return out
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffHosts(t *testing.T) {
+	old := []HostConfig{
+		{Name: "a"},
+		{Name: "b", Env: "prod"},
+		{Name: "c", Databases: []DatabaseOverride{{Container: "pg"}}},
+	}
+	new := []HostConfig{
+		{Name: "a"},
+		{Name: "b", Env: "staging"},
+		{Name: "c", Databases: []DatabaseOverride{{Container: "pg", Auto: true}}},
+		{Name: "d"},
+	}
+	d := diffHosts(old, new[1:])
+	want := hostDiff{added: []string{"d"}, changed: []string{"b", "c"}, removed: []string{"a"}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("diffHosts = %+v, want %+v", d, want)
+	}
+	if got := d.String(); got != "1 host added, 2 hosts changed, 1 host removed" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := diffHosts(old, old).String(); got != "no host changes" {
+		t.Errorf("no-op String() = %q", got)
+	}
+}
+
+func TestConfigStamp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("hosts:\n  - name: a\n"), 0o600)
+	cfg := &Config{}
+
+	stamp := configStamp(path, cfg)
+	if configStamp(path, cfg) != stamp {
+		t.Fatal("stamp changed without an edit")
+	}
+	os.MkdirAll(filepath.Join(dir, configDirName), 0o755)
+	os.WriteFile(filepath.Join(dir, configDirName, "team.yaml"), []byte("hosts: []\n"), 0o600)
+	if configStamp(path, cfg) == stamp {
+		t.Error("new fragment did not change the stamp")
+	}
+}
+
+func TestSaveConfigConflict(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("hosts:\n  - name: a\n"), 0o600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModel(cfg, path)
+
+	// Our own save doesn't count as an outside change.
+	m.cfg.Hosts[0].Env = "dev"
+	if _, err := m.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := m.checkConfigChange(); cmds != nil {
+		t.Error("own save triggered a reload")
+	}
+
+	external := "hosts:\n  - name: a\n    env: dev\nbackup_dir: /tmp/elsewhere\n"
+	os.WriteFile(path, []byte(external), 0o600)
+	m.cfg.BackupDir = "/tmp/mine"
+	_, err = m.saveConfig()
+	if !errors.Is(err, errConfigChanged) {
+		t.Fatalf("saveConfig err = %v, want errConfigChanged", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != external {
+		t.Errorf("outside edit was overwritten:\n%s", data)
+	}
+	if m.cfg.BackupDir != "/tmp/elsewhere" || m.cfg.Hosts[0].Env != "dev" {
+		t.Errorf("config not reloaded from disk: %+v", m.cfg)
+	}
+	if !strings.Contains(m.flash, "no host changes") {
+		t.Errorf("flash = %q", m.flash)
+	}
+}
+
+func TestSaveConfigFlashReappliesEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("hosts:\n  - name: a\n"), 0o600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModel(cfg, path)
+
+	os.WriteFile(path, []byte("hosts:\n  - name: a\nbackup_dir: /tmp/elsewhere\n"), 0o600)
+	_, saved := m.saveConfigFlash("saved", func() { m.cfg.Hosts[0].Env = "dev" })
+	if !saved {
+		t.Fatalf("edit not saved after a conflict: %q", m.flash)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"env: dev", "backup_dir: /tmp/elsewhere"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+	if !strings.Contains(m.flash, "outside drillbit") {
+		t.Errorf("flash = %q", m.flash)
+	}
+
+	// A file that no longer loads can't be merged with.
+	os.WriteFile(path, []byte("hosts: [\n"), 0o600)
+	if _, saved := m.saveConfigFlash("saved", func() { m.cfg.Hosts[0].Env = "prod" }); saved {
+		t.Error("saved over a file that doesn't load")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "hosts: [\n" {
+		t.Errorf("broken file was overwritten:\n%s", data)
+	}
+	if env := m.cfg.Hosts[0].Env; env != "dev" {
+		t.Errorf("unsaved edit kept in memory: env = %q", env)
+	}
+}
+
+func TestReloadDuringDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("hosts:\n  - name: a\n"), 0o600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newModel(cfg, path)
+	m.discovering = true
+	m.hostsTotal = 1
+
+	os.WriteFile(path, []byte("hosts:\n  - name: a\n    env: dev\n  - name: b\n"), 0o600)
+	m.reloadConfig()
+	if m.hostsTotal != 1 {
+		t.Error("reload started a second discovery")
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(m.queuedRescan, want) {
+		t.Errorf("queuedRescan = %v, want %v", m.queuedRescan, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Image types with a badge of their own; see imageTypeBadge.
+var imageTypes = []string{"postgres", "postgis", "timescale"}
+
+// DiscoveryRule says which containers are databases and where their
+// credentials are. Rules are tried in order and the first that matches a
+// container decides; see ruleFor.
+type DiscoveryRule struct {
+	Type        string   `yaml:"type,omitempty"`         // image badge; derived from the image name if empty
+	Images      []string `yaml:"images,omitempty"`       // regexes matched against the image, e.g. "^bitnami/postgresql:"
+	Include     []string `yaml:"include,omitempty"`      // container name globs the rule is for; empty means all
+	Exclude     []string `yaml:"exclude,omitempty"`      // container name globs to hide
+	UserEnv     []string `yaml:"user_env,omitempty"`     // env vars to read, first set wins
+	PasswordEnv []string `yaml:"password_env,omitempty"` // ditto
+	DatabaseEnv []string `yaml:"database_env,omitempty"` // ditto
+}
+
+// builtinDiscoveryRules come after any configured rules: the official
+// postgres image and its postgis and timescale relatives.
+var builtinDiscoveryRules = []DiscoveryRule{{
+	Images:      []string{"(?i)postgres|postgis|timescale"},
+	UserEnv:     []string{"POSTGRES_USER"},
+	PasswordEnv: []string{"POSTGRES_PASSWORD"},
+	DatabaseEnv: []string{"POSTGRES_DB"},
+}}
+
+// discoveryRule is a DiscoveryRule ready to match, with env lists left
+// empty filled in from the built-in rule.
+type discoveryRule struct {
+	DiscoveryRule
+	images []*regexp.Regexp
+}
+
+// compileRules prepares rules for matching. Patterns are checked when the
+// config loads, so one that doesn't compile here is skipped.
+func compileRules(rules []DiscoveryRule) []discoveryRule {
+	out := make([]discoveryRule, 0, len(rules))
+	for _, r := range rules {
+		cr := discoveryRule{DiscoveryRule: r}
+		for _, p := range r.Images {
+			if re, err := regexp.Compile(p); err == nil {
+				cr.images = append(cr.images, re)
+			}
+		}
+		builtin := builtinDiscoveryRules[0]
+		cr.UserEnv = firstNonEmptyList(r.UserEnv, builtin.UserEnv)
+		cr.PasswordEnv = firstNonEmptyList(r.PasswordEnv, builtin.PasswordEnv)
+		cr.DatabaseEnv = firstNonEmptyList(r.DatabaseEnv, builtin.DatabaseEnv)
+		out = append(out, cr)
+	}
+	return out
+}
+
+func firstNonEmptyList(a, b []string) []string {
+	if len(a) > 0 {
+		return a
+	}
+	return b
+}
+
+// discoveryRules returns the rules for discovery on a host: its own, then
+// the config's, then the built-in ones.
+func (hc HostConfig) discoveryRules() []discoveryRule {
+	rules := append(append(append([]DiscoveryRule(nil), hc.Discovery...), hc.globalDiscovery...), builtinDiscoveryRules...)
+	return compileRules(rules)
+}
+
+// ruleFor returns the rule that decides about a container: the first
+// whose images and include globs both match. If that rule excludes the
+// name, or no rule matches, it returns nil and the container is skipped.
+func ruleFor(rules []discoveryRule, image, name string) *discoveryRule {
+	for i := range rules {
+		r := &rules[i]
+		if !r.matchesImage(image) || (len(r.Include) > 0 && !matchAnyGlob(r.Include, name)) {
+			continue
+		}
+		if matchAnyGlob(r.Exclude, name) {
+			return nil
+		}
+		return r
+	}
+	return nil
+}
+
+func (r *discoveryRule) matchesImage(image string) bool {
+	if len(r.Images) == 0 {
+		return true
+	}
+	for _, re := range r.images {
+		if re.MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageType returns the badge type for a container the rule matched.
+func (r *discoveryRule) imageType(image string) string {
+	return firstNonEmpty(r.Type, simplifyImageName(image))
+}
+
+func matchAnyGlob(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// envVarPattern matches environment variable names.
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnv splits a container's NAME=value environment into a map.
+func parseEnv(vars []string) map[string]string {
+	env := make(map[string]string, len(vars))
+	for _, v := range vars {
+		if k, val, ok := strings.Cut(v, "="); ok {
+			env[k] = val
+		}
+	}
+	return env
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRuleFor(t *testing.T) {
+	rules := compileRules(append([]DiscoveryRule{
+		{Type: "postgres", Images: []string{`^bitnami/postgresql:`}, PasswordEnv: []string{"POSTGRESQL_PASSWORD"}},
+		{Images: []string{`postgres`}, Exclude: []string{"*_replica"}},
+		{Type: "postgres", Include: []string{"billing_*"}},
+	}, builtinDiscoveryRules...))
+
+	tests := []struct {
+		image, name string
+		want        int // index of the rule, -1 for none
+	}{
+		{"bitnami/postgresql:16", "app_db", 0},
+		{"postgres:16", "app_db", 1},
+		{"postgres:16", "app_replica", -1}, // excluded, not passed on
+		{"registry.local/billing:3", "billing_db", 2},
+		{"timescale/timescaledb:2.9", "metrics", 3},
+		{"redis:7", "cache", -1},
+	}
+	for _, tt := range tests {
+		got := ruleFor(rules, tt.image, tt.name)
+		switch {
+		case tt.want < 0 && got != nil:
+			t.Errorf("ruleFor(%s, %s) = %+v, want none", tt.image, tt.name, got.DiscoveryRule)
+		case tt.want >= 0 && got != &rules[tt.want]:
+			t.Errorf("ruleFor(%s, %s) = %+v, want rule %d", tt.image, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseDockerInspectRules(t *testing.T) {
+	rules := compileRules(append([]DiscoveryRule{{
+		Type:        "postgres",
+		Images:      []string{`^bitnami/postgresql`},
+		UserEnv:     []string{"POSTGRESQL_USERNAME", "POSTGRES_USER"},
+		PasswordEnv: []string{"POSTGRESQL_PASSWORD", "POSTGRES_PASSWORD"},
+		DatabaseEnv: []string{"POSTGRESQL_DATABASE"},
+	}}, builtinDiscoveryRules...))
+
+	input := inspectJSON(t,
+		runningContainer("orders", "bitnami/postgresql:16", "POSTGRESQL_USERNAME=orders", "POSTGRESQL_PASSWORD=pw1", "POSTGRESQL_DATABASE=orders"),
+		runningContainer("legacy", "bitnami/postgresql:11", "POSTGRES_PASSWORD=pw2"),
+		runningContainer("cache", "redis:7", "REDIS_PASSWORD=pw3"),
+	)
+	got, err := parseDockerContainers(input, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("containers = %+v, want orders and legacy", got)
+	}
+	if c := got[0]; c.image != "postgres" || c.dbUser != "orders" || c.password != "pw1" || c.database != "orders" {
+		t.Errorf("orders = %+v", c)
+	}
+	// Later names in a list are fallbacks; unset ones fall back to the defaults.
+	if c := got[1]; c.dbUser != "postgres" || c.password != "pw2" || c.database != "legacy" {
+		t.Errorf("legacy = %+v", c)
+	}
+}
+
+func TestLoadConfigDiscoveryRules(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, filepath.Join(dir, configDirName, "team.yaml"), `discovery:
+  - images: ["^supabase/postgres"]
+hosts:
+  - name: server1
+    discovery:
+      - include: ["team_*"]
+`)
+	own := `version: 1
+discovery:
+  - images: ["^bitnami/postgresql"]
+    password_env: [POSTGRESQL_PASSWORD]
+hosts:
+  - name: server1
+    discovery:
+      - include: ["mine_*"]
+  - name: server2
+`
+	writeTestFile(t, configPath, own)
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Host rules first, the user's before the team's, then global rules
+	// the same way, then the built-in ones.
+	var images []string
+	for _, r := range cfg.Hosts[0].discoveryRules() {
+		images = append(images, firstNonEmptyList(r.Include, r.Images)[0])
+	}
+	want := []string{"mine_*", "team_*", "^bitnami/postgresql", "^supabase/postgres", builtinDiscoveryRules[0].Images[0]}
+	if len(images) != len(want) {
+		t.Fatalf("server1 rules = %q, want %q", images, want)
+	}
+	for i := range want {
+		if images[i] != want[i] {
+			t.Errorf("server1 rule %d = %q, want %q", i, images[i], want[i])
+		}
+	}
+	if n := len(cfg.Hosts[1].discoveryRules()); n != 3 {
+		t.Errorf("server2 has %d rules, want 3", n)
+	}
+
+	// Shared rules stay out of the personal file.
+	if err := SaveConfig(cfg, configPath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != own {
+		t.Errorf("saved config =\n%s", data)
+	}
+}
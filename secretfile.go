@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// secretFileSuffix marks an env var that names a file holding the value,
+// the Docker secrets convention: POSTGRES_PASSWORD_FILE=/run/secrets/pw.
+const secretFileSuffix = "_FILE"
+
+// secretFile is a credential a container keeps in a file rather than in
+// its environment. It is read with docker exec once the container is
+// known; see readSecretFiles.
+type secretFile struct {
+	field string // "user", "password" or "database"
+	env   string // the *_FILE variable, for messages
+	path  string // inside the container
+}
+
+// secretError is a secret file that couldn't be read.
+type secretError struct {
+	field string
+	msg   string
+}
+
+// lookupEnvOrFile returns the value of the first of names set in env.
+// Each name may also be set as NAME_FILE; if that comes first, the
+// variable and the path of the file holding the value are returned
+// instead.
+func lookupEnvOrFile(env map[string]string, names []string) (value, fileEnv, path string) {
+	for _, n := range names {
+		if v := env[n]; v != "" {
+			return v, "", ""
+		}
+		if p := env[n+secretFileSuffix]; p != "" {
+			return "", n + secretFileSuffix, p
+		}
+	}
+	return "", "", ""
+}
+
+// set stores a credential read from a secret file.
+func (c *containerInfo) set(field, value string) {
+	switch field {
+	case "user":
+		c.dbUser = value
+	case "password":
+		c.password = value
+	case "database":
+		c.database = value
+	}
+}
+
+// sets reports whether an override (possibly nil) gives a value for a
+// credential field.
+func (d *DatabaseOverride) sets(field string) bool {
+	if d == nil {
+		return false
+	}
+	switch field {
+	case "user":
+		return d.User != ""
+	case "password":
+		return d.Password != ""
+	case "database":
+		return d.Database != ""
+	}
+	return false
+}
+
+// applyDefaults fills in the user and database a container didn't set.
+func (c *containerInfo) applyDefaults() {
+	c.dbUser = firstNonEmpty(c.dbUser, "postgres")
+	c.database = firstNonEmpty(c.database, c.name) // default to container name
+}
+
+// readSecretFiles reads the secret files of containers by running cat in
+// them, in place. A file that can't be read is recorded on the
+// container, which is kept so the problem shows up next to it.
+func readSecretFiles(engine containerEngine, deadline time.Time, containers []containerInfo) {
+	for i := range containers {
+		c := &containers[i]
+		for _, s := range c.secrets {
+			value, err := "", errors.New("discovery timed out")
+			if left := time.Until(deadline); left > 0 {
+				value, err = runInContainer(engine, c.name, []string{"cat", "--", s.path}, left)
+			}
+			if err != nil {
+				c.secretErrs = append(c.secretErrs, secretError{s.field, fmt.Sprintf("%s: can't read %s: %v", s.env, s.path, err)})
+				continue
+			}
+			c.set(s.field, value)
+		}
+		c.secrets = nil
+		c.applyDefaults()
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestParseDockerInspectSecretFiles(t *testing.T) {
+	input := inspectJSON(t,
+		runningContainer("app_db", "postgres:16",
+			"POSTGRES_USER_FILE=/run/secrets/db_user", "POSTGRES_PASSWORD_FILE=/run/secrets/db_password", "POSTGRES_DB=app"),
+		runningContainer("both", "postgres:16", "POSTGRES_PASSWORD=plain", "POSTGRES_PASSWORD_FILE=/run/secrets/ignored"),
+	)
+	containers, err := parseDockerInspect(input, compileRules(builtinDiscoveryRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("containers = %+v", containers)
+	}
+
+	c := containers[0]
+	want := []secretFile{
+		{field: "user", env: "POSTGRES_USER_FILE", path: "/run/secrets/db_user"},
+		{field: "password", env: "POSTGRES_PASSWORD_FILE", path: "/run/secrets/db_password"},
+	}
+	if len(c.secrets) != len(want) || c.secrets[0] != want[0] || c.secrets[1] != want[1] {
+		t.Errorf("secrets = %+v, want %+v", c.secrets, want)
+	}
+	// Defaults wait until the files are read.
+	if c.dbUser != "" || c.password != "" || c.database != "app" {
+		t.Errorf("app_db = %+v", c)
+	}
+
+	// A plain value comes before its _FILE form.
+	if c := containers[1]; c.password != "plain" || len(c.secrets) != 0 || c.dbUser != "postgres" {
+		t.Errorf("both = %+v", c)
+	}
+}
+
+func TestWithPasswordKeepsSecretErrors(t *testing.T) {
+	containers := []containerInfo{
+		{name: "no_creds"},
+		{name: "unreadable", secretErrs: []secretError{{"password", "POSTGRES_PASSWORD_FILE: can't read /run/secrets/pw: permission denied"}}},
+		{name: "ok", password: "pw"},
+	}
+	got := withPassword(containers)
+	if len(got) != 2 || got[0].name != "unreadable" || got[1].name != "ok" {
+		t.Errorf("withPassword = %+v", got)
+	}
+}
+
+func TestOverrideSets(t *testing.T) {
+	var none *DatabaseOverride
+	if none.sets("password") {
+		t.Error("nil override sets password")
+	}
+	d := &DatabaseOverride{Password: "vault:app"}
+	if !d.sets("password") || d.sets("user") || d.sets("database") {
+		t.Errorf("sets on %+v", d)
+	}
+}
@@ -35,7 +35,7 @@ func newSSHPool() *sshPool {
 
 // Acquire returns a shared SSH client for the host, dialing a new connection
 // if one doesn't exist or the existing one is dead. Caller must Release when done.
-func (p *sshPool) Acquire(sshHost string) (*ssh.Client, error) {
+func (p *sshPool) Acquire(sshHost string, keepAliveInterval time.Duration) (*ssh.Client, error) {
 	p.mu.Lock()
 	if pc, ok := p.conns[sshHost]; ok {
 		select {
@@ -61,7 +61,7 @@ func (p *sshPool) Acquire(sshHost string) (*ssh.Client, error) {
 		client.Wait()
 		close(dead)
 	}()
-	go keepAlive(client, 30*time.Second, dead)
+	go keepAlive(client, keepAliveInterval, dead)
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -141,81 +141,110 @@ func keepAlive(client *ssh.Client, interval time.Duration, stop <-chan struct{})
 
 // --- SSH dial and helpers ---
 
-// dialSSH establishes an SSH connection to the given host.
-// sshHost can be "user@host" or just "host". SSH config (~/.ssh/config)
-// is consulted for HostName, User, Port, IdentityFile, and IdentityAgent.
-func dialSSH(sshHost string) (*ssh.Client, error) {
-	var alias, explicitUser string
+// sshTarget is a host alias resolved against ~/.ssh/config.
+type sshTarget struct {
+	alias          string
+	user           string
+	hostname       string
+	port           int
+	agentSocket    string // "" if neither IdentityAgent nor SSH_AUTH_SOCK is set
+	identitiesOnly bool
+	keyPaths       []string // IdentityFile entries, or the default key names
+	defaultKeys    bool     // keyPaths are defaults, not set in ~/.ssh/config
+}
+
+// addr returns the host:port to dial.
+func (t sshTarget) addr() string {
+	return net.JoinHostPort(t.hostname, strconv.Itoa(t.port))
+}
+
+// resolveSSHTarget resolves sshHost ("user@host" or "host") using
+// ~/.ssh/config for HostName, User, Port, IdentityFile, IdentitiesOnly
+// and IdentityAgent.
+func resolveSSHTarget(sshHost string) sshTarget {
+	var t sshTarget
+	var explicitUser string
 	if at := strings.LastIndex(sshHost, "@"); at >= 0 {
 		explicitUser = sshHost[:at]
-		alias = sshHost[at+1:]
+		t.alias = sshHost[at+1:]
 	} else {
-		alias = sshHost
+		t.alias = sshHost
 	}
+	alias := t.alias
 
-	hostname := sshconfig.Get(alias, "HostName")
-	if hostname == "" || hostname == alias {
-		hostname = alias
+	t.hostname = sshconfig.Get(alias, "HostName")
+	if t.hostname == "" {
+		t.hostname = alias
 	}
 
-	user := explicitUser
-	if user == "" {
-		if u := sshconfig.Get(alias, "User"); u != "" {
-			user = u
-		}
+	t.user = explicitUser
+	if t.user == "" {
+		t.user = sshconfig.Get(alias, "User")
 	}
-	if user == "" {
-		user = os.Getenv("USER")
+	if t.user == "" {
+		t.user = os.Getenv("USER")
 	}
 
-	port := 22
+	t.port = 22
 	if p := sshconfig.Get(alias, "Port"); p != "" {
 		if n, err := strconv.Atoi(p); err == nil {
-			port = n
+			t.port = n
 		}
 	}
 
-	// Connect to SSH agent (kept alive through the Dial handshake).
-	var agentConn net.Conn
-	agentSocket := expandTilde(sshconfig.Get(alias, "IdentityAgent"))
-	if agentSocket == "" {
-		agentSocket = os.Getenv("SSH_AUTH_SOCK")
-	}
-	if agentSocket != "" {
-		agentConn, _ = net.Dial("unix", agentSocket)
+	t.agentSocket = expandTilde(sshconfig.Get(alias, "IdentityAgent"))
+	if t.agentSocket == "" {
+		t.agentSocket = os.Getenv("SSH_AUTH_SOCK")
 	}
 
-	// Build auth methods.
-	var authMethods []ssh.AuthMethod
-
-	if agentConn != nil {
-		agentClient := agent.NewClient(agentConn)
-		authMethods = append(authMethods, ssh.PublicKeysCallback(agentClient.Signers))
-	}
-
-	// Key file auth.
-	identitiesOnly := strings.EqualFold(sshconfig.Get(alias, "IdentitiesOnly"), "yes")
-	identityFiles := sshconfig.GetAll(alias, "IdentityFile")
+	t.identitiesOnly = strings.EqualFold(sshconfig.Get(alias, "IdentitiesOnly"), "yes")
 
 	// Filter empty defaults and expand paths.
-	var keyPaths []string
+	identityFiles := sshconfig.GetAll(alias, "IdentityFile")
+	t.defaultKeys = len(identityFiles) == 1 && identityFiles[0] == sshconfig.Default("IdentityFile")
 	for _, f := range identityFiles {
 		f = expandTilde(strings.TrimSpace(f))
 		if f != "" {
-			keyPaths = append(keyPaths, f)
+			t.keyPaths = append(t.keyPaths, f)
 		}
 	}
 
-	if len(keyPaths) == 0 && !identitiesOnly {
+	if len(t.keyPaths) == 0 && !t.identitiesOnly {
 		home, _ := os.UserHomeDir()
-		keyPaths = []string{
+		t.keyPaths = []string{
 			filepath.Join(home, ".ssh", "id_ed25519"),
 			filepath.Join(home, ".ssh", "id_ecdsa"),
 			filepath.Join(home, ".ssh", "id_rsa"),
 		}
+		t.defaultKeys = true
+	}
+
+	return t
+}
+
+// dialSSH establishes an SSH connection to the given host.
+// sshHost can be "user@host" or just "host". SSH config (~/.ssh/config)
+// is consulted via resolveSSHTarget.
+func dialSSH(sshHost string) (*ssh.Client, error) {
+	t := resolveSSHTarget(sshHost)
+	alias := t.alias
+
+	// Connect to SSH agent (kept alive through the Dial handshake).
+	var agentConn net.Conn
+	if t.agentSocket != "" {
+		agentConn, _ = net.Dial("unix", t.agentSocket)
 	}
 
-	for _, kf := range keyPaths {
+	// Build auth methods.
+	var authMethods []ssh.AuthMethod
+
+	if agentConn != nil {
+		agentClient := agent.NewClient(agentConn)
+		authMethods = append(authMethods, ssh.PublicKeysCallback(agentClient.Signers))
+	}
+
+	// Key file auth.
+	for _, kf := range t.keyPaths {
 		if signer := loadKeyFile(kf); signer != nil {
 			authMethods = append(authMethods, ssh.PublicKeys(signer))
 		}
@@ -237,13 +266,13 @@ func dialSSH(sshHost string) (*ssh.Client, error) {
 	}
 
 	cfg := &ssh.ClientConfig{
-		User:            user,
+		User:            t.user,
 		Auth:            authMethods,
 		HostKeyCallback: hostKeyCallback,
 		Timeout:         10 * time.Second,
 	}
 
-	addr := net.JoinHostPort(hostname, strconv.Itoa(port))
+	addr := t.addr()
 
 	// Use net.Dialer with TCP keepalive enabled so the OS sends TCP-level
 	// keepalive probes. This prevents intermediate network devices (NATs,
@@ -293,7 +322,7 @@ func hostKeyTOFU() (ssh.HostKeyCallback, error) {
 		return nil, fmt.Errorf("home dir: %w", err)
 	}
 
-	knownHostsFile := filepath.Join(home, ".ssh", "known_hosts")
+	knownHostsFile := knownHostsPath(home)
 
 	// Ensure the file exists.
 	if _, err := os.Stat(knownHostsFile); os.IsNotExist(err) {
@@ -337,17 +366,25 @@ func hostKeyTOFU() (ssh.HostKeyCallback, error) {
 	}, nil
 }
 
+// knownHostsPath returns the known_hosts file DrillBit verifies against.
+func knownHostsPath(home string) string {
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
 // loadKeyFile attempts to load and parse an SSH private key file.
 func loadKeyFile(path string) ssh.Signer {
+	signer, _ := parseKeyFile(path)
+	return signer
+}
+
+// parseKeyFile reads and parses an SSH private key file, returning the
+// reason if it cannot be used.
+func parseKeyFile(path string) (ssh.Signer, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil
-	}
-	signer, err := ssh.ParsePrivateKey(data)
-	if err != nil {
-		return nil
+		return nil, err
 	}
-	return signer
+	return ssh.ParsePrivateKey(data)
 }
 
 // expandTilde expands a leading ~ to the user's home directory.
@@ -359,15 +396,23 @@ func expandTilde(p string) string {
 	return p
 }
 
-// dockerCmd returns the docker command prefix for a given SSH client.
-// It probes whether the user can run docker without sudo, falling back
-// to "sudo docker" only if needed.
-func dockerCmd(client *ssh.Client) string {
-	_, err := runSSHCommand(client, "docker info >/dev/null 2>&1")
+// dockerCmd returns the container CLI command prefix for a host, e.g.
+// "docker" or "sudo podman", following its sudo policy. With the auto
+// policy it probes whether the CLI works without sudo, falling back to
+// sudo only if needed.
+func dockerCmd(client *ssh.Client, rt HostRuntime) string {
+	cli := rt.containerCLI()
+	switch rt.Sudo {
+	case sudoAlways:
+		return "sudo " + cli
+	case sudoNever:
+		return cli
+	}
+	_, err := runSSHCommand(client, cli+" info >/dev/null 2>&1", rt.commandTimeout())
 	if err == nil {
-		return "docker"
+		return cli
 	}
-	return "sudo docker"
+	return "sudo " + cli
 }
 
 // shellQuote quotes a string for safe interpolation into a remote shell command.
@@ -376,7 +421,7 @@ func shellQuote(s string) string {
 }
 
 // runSSHCommand executes a command on an existing SSH connection with a timeout.
-func runSSHCommand(client *ssh.Client, cmd string) (string, error) {
+func runSSHCommand(client *ssh.Client, cmd string, timeout time.Duration) (string, error) {
 	session, err := client.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("create session: %w", err)
@@ -399,8 +444,8 @@ func runSSHCommand(client *ssh.Client, cmd string) (string, error) {
 			return "", fmt.Errorf("run command: %w", r.err)
 		}
 		return strings.TrimSpace(string(r.out)), nil
-	case <-time.After(30 * time.Second):
+	case <-time.After(timeout):
 		session.Close()
-		return "", fmt.Errorf("command timed out after 30s")
+		return "", fmt.Errorf("command timed out after %s", timeout)
 	}
 }
@@ -57,3 +57,21 @@ func TestExpandTilde(t *testing.T) {
 		})
 	}
 }
+
+func TestDockerCmdPolicy(t *testing.T) {
+	// The fixed policies never probe the host, so no client is needed.
+	tests := []struct {
+		rt   HostRuntime
+		want string
+	}{
+		{HostRuntime{Sudo: sudoNever}, "docker"},
+		{HostRuntime{Sudo: sudoAlways}, "sudo docker"},
+		{HostRuntime{Docker: "podman", Sudo: sudoAlways}, "sudo podman"},
+		{HostRuntime{Docker: "docker -H unix:///run/user/1000/docker.sock", Sudo: sudoNever}, "docker -H unix:///run/user/1000/docker.sock"},
+	}
+	for _, tt := range tests {
+		if got := dockerCmd(nil, tt.rt); got != tt.want {
+			t.Errorf("dockerCmd(%+v) = %q, want %q", tt.rt, got, tt.want)
+		}
+	}
+}
@@ -129,6 +129,7 @@ var (
 	logTagOK   = lipgloss.NewStyle().Foreground(lipgloss.Color("#51CF66")).Bold(true)
 	logTagScan = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFD93D")).Bold(true)
 	logTagFind = lipgloss.NewStyle().Foreground(lipgloss.Color("#74C0FC")).Bold(true)
+	logTagWarn = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA94D")).Bold(true)
 	logTagErr  = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF6B6B")).Bold(true)
 	logTagDim  = lipgloss.NewStyle().Foreground(lipgloss.Color("#555555"))
 	logText    = lipgloss.NewStyle().Foreground(lipgloss.Color("#AAAAAA"))
@@ -171,6 +172,8 @@ func renderLogEntry(e logEntry) string {
 		tag = logTagScan.Render("[SCAN]")
 	case "FIND":
 		tag = logTagFind.Render("[FIND]")
+	case "WARN":
+		tag = logTagWarn.Render("[WARN]")
 	case "ERR":
 		tag = logTagErr.Render("[ ERR]")
 	default:
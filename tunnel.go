@@ -1,10 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
@@ -25,10 +25,11 @@ type TunnelManager struct {
 
 // Tunnel represents a single port-forward over a shared SSH connection.
 type Tunnel struct {
-	sshHost   string       // pool key for Release
-	container string       // container name for IP resolution on reconnect
-	localPort uint16       // local listen port (preserved across reconnects)
-	listener  net.Listener // local TCP listener
+	sshHost   string        // pool key for Release
+	container string        // container name for IP resolution on reconnect
+	runtime   HostRuntime   // host settings, kept for reconnects
+	localPort uint16        // local listen port (preserved across reconnects)
+	listener  net.Listener  // local TCP listener
 	done      chan struct{} // closed when the accept loop exits
 }
 
@@ -86,19 +87,22 @@ func (tm *TunnelManager) IsAlive(key string) bool {
 // --- Tunnel setup (shared between Connect and reconnect) ---
 
 // setupTunnel creates a port-forward tunnel. It acquires an SSH connection,
-// resolves the container IP, starts a local listener, and launches the
-// accept/forward loop. On success the caller is responsible for eventually
-// closing the tunnel and releasing the pool reference.
-func (tm *TunnelManager) setupTunnel(sshHost, container string, localPort uint16) (*Tunnel, string, error) {
-	client, err := tm.pool.Acquire(sshHost)
+// resolves the container IP unless discovery already gave one in ip,
+// starts a local listener, and launches the accept/forward loop. On
+// success the caller is responsible for eventually closing the tunnel and
+// releasing the pool reference.
+func (tm *TunnelManager) setupTunnel(sshHost, container, ip string, localPort uint16, rt HostRuntime) (*Tunnel, string, error) {
+	client, err := tm.pool.Acquire(sshHost, rt.keepAlive())
 	if err != nil {
 		return nil, "", fmt.Errorf("ssh: %w", err)
 	}
 
-	ip, err := resolveContainerIP(client, container)
-	if err != nil {
-		tm.pool.Release(sshHost)
-		return nil, "", fmt.Errorf("resolve IP: %w", err)
+	if ip == "" {
+		ip, err = resolveContainerIP(client, container, rt)
+		if err != nil {
+			tm.pool.Release(sshHost)
+			return nil, "", fmt.Errorf("resolve IP: %w", err)
+		}
 	}
 
 	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
@@ -112,6 +116,7 @@ func (tm *TunnelManager) setupTunnel(sshHost, container string, localPort uint16
 	tun := &Tunnel{
 		sshHost:   sshHost,
 		container: container,
+		runtime:   rt,
 		localPort: localPort,
 		listener:  listener,
 		done:      done,
@@ -135,6 +140,9 @@ func (tm *TunnelManager) setupTunnel(sshHost, container string, localPort uint16
 			go forward(local, remote)
 		}
 	}()
+	if rt.DockerSocket != "" {
+		go followRestarts(dockerAPIOver(client, rt.DockerSocket), container, tun)
+	}
 
 	return tun, ip, nil
 }
@@ -152,8 +160,13 @@ func (tm *TunnelManager) Connect(entry *Entry) tea.Cmd {
 		if tm.Status(key) == TunnelAlive {
 			return tunnelConnectedMsg{key: key}
 		}
+		// Port 0 would listen on a random port. The entry's error says
+		// why AssignPorts left it without one.
+		if entry.LocalPort == 0 {
+			return tunnelErrorMsg{key: key, err: errors.New(firstNonEmpty(entry.Error, "no local port assigned"))}
+		}
 
-		tun, ip, err := tm.setupTunnel(entry.SSHHost, entry.Container, entry.LocalPort)
+		tun, ip, err := tm.setupTunnel(entry.SSHHost, entry.dockerName(), entry.ContainerIP, entry.LocalPort, entry.runtime)
 		if err != nil {
 			return tunnelErrorMsg{key: key, err: err}
 		}
@@ -326,7 +339,8 @@ func (tm *TunnelManager) reconnectWithBackoff(key string, old *Tunnel) bool {
 		}
 		tm.mu.Unlock()
 
-		newTun, _, err := tm.setupTunnel(old.sshHost, old.container, old.localPort)
+		// The container may have come back with a new address.
+		newTun, _, err := tm.setupTunnel(old.sshHost, old.container, "", old.localPort, old.runtime)
 		if err != nil {
 			continue // retry
 		}
@@ -377,24 +391,35 @@ func forward(local, remote net.Conn) {
 	<-errc
 }
 
-// resolveContainerIP gets the Docker container IP by name using docker inspect.
-// Tries without sudo first, falls back to sudo if needed.
-func resolveContainerIP(client *ssh.Client, containerName string) (string, error) {
-	docker := dockerCmd(client)
-	script := fmt.Sprintf(
-		`%s inspect %s -f '{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}'`,
-		docker, shellQuote(containerName),
-	)
-	ip, err := runSSHCommand(client, script)
+// resolveContainerIP gets the Docker container IP by name from the host's
+// container engine. Discovery normally finds it already; this is for
+// reconnects, when the container may have moved.
+func resolveContainerIP(client *ssh.Client, containerName string, rt HostRuntime) (string, error) {
+	c, err := newContainerEngine(client, rt).inspect(containerName, rt.commandTimeout())
 	if err != nil {
 		return "", fmt.Errorf("resolve container IP for %s: %w", containerName, err)
 	}
-	if ip == "" {
+	if c.ip() == "" {
 		return "", fmt.Errorf("empty container IP for %s — is the container running?", containerName)
 	}
-	// If multiple IPs returned (multiple networks), take the first.
-	if idx := strings.Index(ip, "\n"); idx >= 0 {
-		ip = ip[:idx]
+	return c.ip(), nil
+}
+
+// followRestarts closes a tunnel's listener when its container starts
+// again, e.g. after docker compose recreated it, so monitor reconnects
+// the tunnel to the container's new address right away rather than on
+// the next connection that fails. It needs the events of the Engine API,
+// so only hosts with docker_socket get it.
+func followRestarts(api *dockerAPI, container string, tun *Tunnel) {
+	events, err := api.events(map[string][]string{
+		"type":      {"container"},
+		"container": {container},
+		"event":     {"start"},
+	}, tun.done)
+	if err != nil {
+		return
+	}
+	if _, ok := <-events; ok {
+		tun.listener.Close()
 	}
-	return ip, nil
 }
@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
@@ -97,8 +98,11 @@ type Model struct {
 	height int
 
 	discovering    bool
+	rescanHosts    []string   // hosts being rediscovered after a reload; nil for a full scan
+	queuedRescan   []string   // hosts a reload changed during a discovery; rescanned after it
 	discoverLog    []logEntry // scrolling progress log
 	pendingEntries []Entry    // accumulated during discovery
+	portWarnings   []string   // config warnings, ports not assigned as asked, port state errors
 	discErrors     []hostError
 	hostsTotal     int // total hosts to scan
 	hostsDone      int // completed hosts counter
@@ -107,6 +111,8 @@ type Model struct {
 
 	flash         string // ephemeral status message
 	tagline       string // random tagline picked at startup
+	profile       string // named profile shown in the header; "" for the default config
+	readOnly      bool   // another instance owns the config: no tunnels or config changes
 	sqlClient     string // "pgcli", "psql", or "" if neither found
 	pendingLaunch string // tunnel key to auto-launch SQL client once connected
 
@@ -133,6 +139,10 @@ type Model struct {
 
 	// Shutdown dissolve animation.
 	dissolve *dissolveState
+
+	// Config file stamp (see configStamp) as last seen by the watcher; the
+	// stamp as last loaded or saved is m.cfg.stamp.
+	seenStamp string
 }
 
 func newModel(cfg *Config, configPath string) Model {
@@ -144,15 +154,19 @@ func newModel(cfg *Config, configPath string) Model {
 		sqlClient = "psql"
 	}
 
+	if cfg.stamp == "" {
+		cfg.stamp = configStamp(configPath, cfg)
+	}
 	return Model{
-		cfg:        cfg,
-		configPath: configPath,
-		tunnels:    NewTunnelManager(),
-		mode:       modeNormal,
-		tagline:    randomTagline(),
-		sqlClient:  sqlClient,
+		cfg:         cfg,
+		configPath:  configPath,
+		tunnels:     NewTunnelManager(),
+		mode:        modeNormal,
+		tagline:     randomTagline(),
+		sqlClient:   sqlClient,
 		discovering: true,
 		hostsTotal:  len(cfg.Hosts),
+		seenStamp:   cfg.stamp,
 	}
 }
 
@@ -179,6 +193,7 @@ func (m Model) Init() tea.Cmd {
 		checkForUpdate(),
 		m.scheduleHealthCheck(),
 		m.scheduleSpinnerTick(),
+		m.scheduleConfigWatch(),
 	)
 }
 
@@ -202,7 +217,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.done {
 			// All hosts finished — finalize.
 			m.discovering = false
-			AssignPorts(m.pendingEntries)
+			rescan := m.rescanHosts
+			m.rescanHosts = nil
+			if rescan != nil {
+				// Only some hosts were scanned: keep everyone else's entries.
+				for _, e := range m.entries {
+					if !slices.Contains(rescan, e.Host) {
+						m.pendingEntries = append(m.pendingEntries, e)
+					}
+				}
+			}
+			ports := loadPortState(m.cfg, m.configPath)
+			m.portWarnings = append([]string(nil), m.cfg.warnings...)
+			for _, c := range AssignPorts(m.pendingEntries, ports) {
+				m.portWarnings = append(m.portWarnings, c.String())
+			}
+			// The instance that owns this config also owns its port state.
+			if !m.readOnly {
+				if err := ports.save(); err != nil {
+					m.portWarnings = append(m.portWarnings, err.Error())
+				}
+			}
 
 			// Merge: carry over status from active tunnels on refresh.
 			existing := make(map[string]*Entry, len(m.entries))
@@ -211,11 +246,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			for i := range m.pendingEntries {
 				key := tunnelKey(&m.pendingEntries[i])
-				if old, ok := existing[key]; ok {
+				// Keep a fresh password resolution error visible.
+				if old, ok := existing[key]; ok && m.pendingEntries[i].Error == "" {
 					m.pendingEntries[i].Status = old.Status
 					m.pendingEntries[i].Error = old.Error
 					m.pendingEntries[i].ContainerIP = old.ContainerIP
-					m.pendingEntries[i].LocalPort = old.LocalPort
+					// A live tunnel keeps listening where it is; a changed
+					// pin applies once it is reconnected.
+					if old.Status == StatusConnected || old.Status == StatusConnecting {
+						m.pendingEntries[i].LocalPort = old.LocalPort
+					}
 				}
 			}
 
@@ -223,9 +263,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.entries = m.pendingEntries
 			m.pendingEntries = nil
 			m.applyFilter()
+			saveDiscoveryCache(m.configPath, m.entries)
 
 			// Summary flash.
-			hosts := m.hostsTotal - len(m.discErrors)
+			failed := 0
+			for _, he := range m.discErrors {
+				if rescan == nil || slices.Contains(rescan, he.host) {
+					failed++
+				}
+			}
+			hosts := m.hostsTotal - failed
 			word := "hosts"
 			if hosts == 1 {
 				word = "host"
@@ -233,8 +280,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.flash = flashStyle.Render(fmt.Sprintf("\u2713 Scan complete \u2014 %d targets across %d %s", m.dbsFound, hosts, word))
 			cmds = append(cmds, m.clearFlashAfter(3*time.Second))
 
-			if firstRun {
+			switch {
+			case m.readOnly:
+			case firstRun:
 				cmds = append(cmds, m.autoconnect()...)
+			case rescan != nil:
+				cmds = append(cmds, m.autoconnectNew(existing)...)
+			}
+			if queued := m.queuedRescan; queued != nil {
+				m.queuedRescan = nil
+				cmds = append(cmds, m.startDiscovery(queued)...)
 			}
 		} else {
 			// Incremental update.
@@ -371,10 +426,21 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+	case ctlRequestMsg:
+		resp, cmd := m.handleControl(msg.req)
+		msg.reply <- resp
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
 	case tunnelHealthMsg:
 		cmds = append(cmds, m.checkTunnelHealth()...)
 		cmds = append(cmds, m.scheduleHealthCheck())
 
+	case configWatchMsg:
+		cmds = append(cmds, m.checkConfigChange()...)
+		cmds = append(cmds, m.scheduleConfigWatch())
+
 	case clipboardClearMsg:
 		clipboard.WriteAll("")
 
@@ -490,6 +556,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m *Model) updateNormal(msg tea.KeyPressMsg) []tea.Cmd {
 	var cmds []tea.Cmd
 
+	if m.readOnly {
+		switch msg.String() {
+		case "space", "enter", "c", "a", "B":
+			m.flash = errorMsgStyle.Render("Read-only: another drillbit owns this config's tunnels and settings")
+			return []tea.Cmd{m.clearFlashAfter(3 * time.Second)}
+		}
+	}
+
 	switch msg.String() {
 	case "ctrl+c":
 		if m.activeConnectionCount() > 0 {
@@ -564,17 +638,15 @@ func (m *Model) updateNormal(msg tea.KeyPressMsg) []tea.Cmd {
 
 	case "a":
 		if e := m.selectedEntry(); e != nil {
-			m.toggleAutoconnect(e)
+			target, on := *e, !m.isAutoconnect(e)
 			label := "off"
-			if m.isAutoconnect(e) {
+			if on {
 				label = "on"
 			}
-			if err := SaveConfig(m.cfg, m.configPath); err != nil {
-				m.flash = errorMsgStyle.Render(fmt.Sprintf("Save: %v", err))
-			} else {
-				m.flash = flashStyle.Render(fmt.Sprintf("Autoconnect %s for %s/%s", label, e.Host, e.Container))
-			}
-			cmds = append(cmds, m.clearFlashAfter(2*time.Second))
+			saveCmds, _ := m.saveConfigFlash(flashStyle.Render(fmt.Sprintf("Autoconnect %s for %s/%s", label, e.Host, e.Container)), func() {
+				m.setAutoconnect(&target, on)
+			})
+			cmds = append(cmds, saveCmds...)
 		}
 
 	case "b":
@@ -612,15 +684,9 @@ func (m *Model) updateNormal(msg tea.KeyPressMsg) []tea.Cmd {
 		}
 
 	case "r", "ctrl+r":
-		m.discovering = true
-		m.discoverLog = nil
-		m.pendingEntries = nil
-		m.discErrors = nil
-		m.hostsDone = 0
-		m.dbsFound = 0
-		m.hostsTotal = len(m.cfg.Hosts)
-		cmds = append(cmds, discoverAll(m.cfg))
-		cmds = append(cmds, m.scheduleSpinnerTick())
+		if !m.discovering {
+			cmds = append(cmds, m.startDiscovery(nil)...)
+		}
 
 	case "?":
 		m.mode = modeHelp
@@ -686,14 +752,26 @@ func (m *Model) updateEditBrowse(msg tea.KeyPressMsg) []tea.Cmd {
 		if e == nil {
 			break
 		}
-		m.setOverrideField(e, m.editRow, "")
-		if err := SaveConfig(m.cfg, m.configPath); err != nil {
-			m.flash = errorMsgStyle.Render(fmt.Sprintf("Save: %v", err))
-		} else {
-			labels := [3]string{"User", "Password", "Database"}
-			m.flash = flashStyle.Render(fmt.Sprintf("%s override cleared", labels[m.editRow]))
+		labels := [3]string{"User", "Password", "Database"}
+		saveCmds, _ := m.saveOverrideField(e, m.editRow, "", flashStyle.Render(fmt.Sprintf("%s override cleared", labels[m.editRow])))
+		cmds = append(cmds, saveCmds...)
+
+	case "p":
+		// Pin the current local port, or unpin it.
+		e := m.selectedEntry()
+		if e == nil {
+			break
+		}
+		port, ok := e.LocalPort, fmt.Sprintf("Port %d pinned", e.LocalPort)
+		if e.PinnedPort != 0 {
+			port, ok = 0, "Port unpinned"
+		}
+		target := *e
+		saveCmds, saved := m.saveConfigFlash(flashStyle.Render(ok), func() { m.setPinnedPort(&target, port) })
+		cmds = append(cmds, saveCmds...)
+		if e = m.selectedEntry(); saved && e != nil {
+			e.PinnedPort = port
 		}
-		cmds = append(cmds, m.clearFlashAfter(2*time.Second))
 	}
 
 	return cmds
@@ -715,24 +793,38 @@ func (m *Model) updateEditInput(msg tea.KeyPressMsg) []tea.Cmd {
 			break
 		}
 		val := strings.TrimSpace(m.editInput.Value())
-		m.setOverrideField(e, m.editRow, val)
-		// Also update live entry value.
-		if val != "" {
+		// The config keeps what was typed, so a secret reference stays a
+		// reference; only the live entry gets the resolved password.
+		var pw string
+		var resolveErr error
+		if val != "" && m.editRow == editFieldPassword {
+			pw, resolveErr = resolveSecret(val, false)
+		}
+		ok := flashStyle.Render("Override saved")
+		if resolveErr != nil {
+			ok = errorMsgStyle.Render(fmt.Sprintf("Override saved, but password override %v", resolveErr))
+		}
+		saveCmds, saved := m.saveOverrideField(e, m.editRow, val, ok)
+		cmds = append(cmds, saveCmds...)
+		// The reload after a conflict may have replaced the entries.
+		if e = m.selectedEntry(); saved && e != nil && val != "" {
 			switch m.editRow {
 			case editFieldUser:
 				e.DBUser = val
 			case editFieldPassword:
-				e.Password = val
+				if resolveErr != nil {
+					e.Status = StatusError
+					e.Error = fmt.Sprintf("password override %v", resolveErr)
+					break
+				}
+				e.Password = pw
+				if e.Status == StatusError {
+					e.Status, e.Error = StatusReady, ""
+				}
 			case editFieldDatabase:
 				e.Database = val
 			}
 		}
-		if err := SaveConfig(m.cfg, m.configPath); err != nil {
-			m.flash = errorMsgStyle.Render(fmt.Sprintf("Save: %v", err))
-		} else {
-			m.flash = flashStyle.Render("Override saved")
-		}
-		cmds = append(cmds, m.clearFlashAfter(2*time.Second))
 		m.editInput.Blur()
 		m.editActive = false
 
@@ -770,6 +862,30 @@ func (m *Model) getOverrideField(e *Entry, field int) string {
 	return ""
 }
 
+// saveOverrideField sets (or clears) one override field of e and saves
+// the config, flashing ok on success. When a vault is unlocked, literal
+// passwords are stored in it and the config gets a vault: reference
+// instead; the vault is put back if the config can't be saved.
+func (m *Model) saveOverrideField(e *Entry, field int, value, ok string) ([]tea.Cmd, bool) {
+	undo := func() error { return nil }
+	if field == editFieldPassword {
+		var err error
+		if value, undo, err = storePassword(e.Host, e.Container, m.getOverrideField(e, field), value); err != nil {
+			m.flash = errorMsgStyle.Render(fmt.Sprintf("Vault: %v", err))
+			return []tea.Cmd{m.clearFlashAfter(2 * time.Second)}, false
+		}
+	}
+	target := *e
+	cmds, saved := m.saveConfigFlash(ok, func() { m.setOverrideField(&target, field, value) })
+	if !saved {
+		// The config still points at what the vault held before.
+		if err := undo(); err != nil {
+			m.flash = errorMsgStyle.Render(fmt.Sprintf("Save failed, and restoring the vault entry: %v", err))
+		}
+	}
+	return cmds, saved
+}
+
 // setOverrideField sets (or clears) one field on a DatabaseOverride, creating it if needed.
 func (m *Model) setOverrideField(e *Entry, field int, value string) {
 	for i := range m.cfg.Hosts {
@@ -783,6 +899,7 @@ func (m *Model) setOverrideField(e *Entry, field int, value string) {
 					m.cfg.Hosts[i].Databases[j].User = value
 				case editFieldPassword:
 					m.cfg.Hosts[i].Databases[j].Password = value
+					m.cfg.Hosts[i].Databases[j].passwordShared = false
 				case editFieldDatabase:
 					m.cfg.Hosts[i].Databases[j].Database = value
 				}
@@ -804,6 +921,20 @@ func (m *Model) setOverrideField(e *Entry, field int, value string) {
 	}
 }
 
+// setPinnedPort sets the pinned port of e's override, creating the
+// override if needed. Port 0 unpins.
+func (m *Model) setPinnedPort(e *Entry, port uint16) {
+	i := hostIndex(m.cfg.Hosts, e.Host)
+	if i < 0 {
+		return
+	}
+	if ov := m.cfg.Hosts[i].GetOverride(e.Container); ov != nil {
+		ov.Port = port
+		return
+	}
+	m.cfg.Hosts[i].Databases = append(m.cfg.Hosts[i].Databases, DatabaseOverride{Container: e.Container, Port: port})
+}
+
 // updateRestorePicker handles key events in the restore file picker.
 func (m *Model) updateRestorePicker(msg tea.KeyPressMsg) []tea.Cmd {
 	switch msg.String() {
@@ -1025,22 +1156,24 @@ func (m *Model) isAutoconnect(e *Entry) bool {
 	return false
 }
 
-func (m *Model) toggleAutoconnect(e *Entry) {
+// setAutoconnect turns autoconnect on or off for e.
+func (m *Model) setAutoconnect(e *Entry, on bool) {
 	// Find the matching host and database in the config
 	for i := range m.cfg.Hosts {
 		if m.cfg.Hosts[i].Name == e.Host {
 			for j := range m.cfg.Hosts[i].Databases {
 				if m.cfg.Hosts[i].Databases[j].Container == e.Container {
-					// Toggle the auto flag
-					m.cfg.Hosts[i].Databases[j].Auto = !m.cfg.Hosts[i].Databases[j].Auto
+					m.cfg.Hosts[i].Databases[j].Auto = on
 					return
 				}
 			}
-			// Database not in config yet - add it with auto:true
-			m.cfg.Hosts[i].Databases = append(m.cfg.Hosts[i].Databases, DatabaseOverride{
-				Container: e.Container,
-				Auto:      true,
-			})
+			// Database not in config yet - add it
+			if on {
+				m.cfg.Hosts[i].Databases = append(m.cfg.Hosts[i].Databases, DatabaseOverride{
+					Container: e.Container,
+					Auto:      true,
+				})
+			}
 			return
 		}
 	}
@@ -1073,6 +1206,49 @@ func (m *Model) autoconnect() []tea.Cmd {
 	return cmds
 }
 
+// autoconnectNew connects autoconnect entries that weren't listed before
+// a rescan. existing holds the entries from before it, by tunnel key.
+func (m *Model) autoconnectNew(existing map[string]*Entry) []tea.Cmd {
+	var cmds []tea.Cmd
+	for i := range m.entries {
+		e := &m.entries[i]
+		if _, ok := existing[tunnelKey(e)]; !ok && e.Status == StatusReady && m.isAutoconnect(e) {
+			e.Status = StatusConnecting
+			cmds = append(cmds, m.tunnels.Connect(e))
+		}
+	}
+	return cmds
+}
+
+// startDiscovery rescans the named hosts, or every configured host if
+// names is nil. Discovery errors for hosts not being rescanned are kept.
+func (m *Model) startDiscovery(names []string) []tea.Cmd {
+	hosts := m.cfg.Hosts
+	var errs []hostError
+	if names != nil {
+		hosts = nil
+		for _, h := range m.cfg.Hosts {
+			if slices.Contains(names, h.Name) {
+				hosts = append(hosts, h)
+			}
+		}
+		for _, he := range m.discErrors {
+			if !slices.Contains(names, he.host) && hostIndex(m.cfg.Hosts, he.host) >= 0 {
+				errs = append(errs, he)
+			}
+		}
+	}
+	m.rescanHosts = names
+	m.discovering = true
+	m.discoverLog = nil
+	m.pendingEntries = nil
+	m.discErrors = errs
+	m.hostsDone = 0
+	m.dbsFound = 0
+	m.hostsTotal = len(hosts)
+	return []tea.Cmd{nextDiscoverEvent(streamDiscovery(hosts)), m.scheduleSpinnerTick()}
+}
+
 func (m *Model) launchSQLClient(e *Entry) tea.Cmd {
 	c := exec.Command(m.sqlClient, connString(e))
 	c.Stdin = os.Stdin
@@ -1275,6 +1451,9 @@ func (m Model) tableRowOffset() int {
 	if !m.discovering && len(m.discErrors) > 0 {
 		offset += len(m.discErrors) + 1
 	}
+	if !m.discovering && len(m.portWarnings) > 0 {
+		offset += len(m.portWarnings) + 1
+	}
 
 	// Filter bar.
 	if m.filterText != "" || m.mode == modeFilter {
@@ -1333,6 +1512,12 @@ func (m Model) buildMainView() string {
 	b.WriteString("\n")
 	title := headerStyle.Render(" \U0001f529 DRILLBIT ")
 	b.WriteString(title)
+	if m.profile != "" {
+		b.WriteString(" " + envColor(m.profile).Bold(true).Render("["+m.profile+"]"))
+	}
+	if m.readOnly {
+		b.WriteString(" " + errorMsgStyle.Render("READ-ONLY"))
+	}
 	if version != "dev" {
 		b.WriteString(dimStyle.Render("  v" + version))
 	}
@@ -1356,6 +1541,12 @@ func (m Model) buildMainView() string {
 	if len(m.discErrors) > 0 {
 		b.WriteString("\n")
 	}
+	for _, w := range m.portWarnings {
+		b.WriteString("  " + statusConnecting.Render("\u26a0 "+w) + "\n")
+	}
+	if len(m.portWarnings) > 0 {
+		b.WriteString("\n")
+	}
 
 	if len(m.entries) == 0 && len(m.discErrors) == 0 {
 		b.WriteString("  " + dimStyle.Render("No databases found.") + "\n")
@@ -1719,8 +1910,8 @@ func (m Model) renderEditOverlay() string {
 		ov := overrides[i]
 		if ov == "" {
 			ov = dimStyle.Render("(none)")
-		} else if i == editFieldPassword {
-			ov = "\u2022\u2022\u2022\u2022\u2022\u2022" // mask password override too
+		} else if i == editFieldPassword && !isSecretRef(ov) {
+			ov = "\u2022\u2022\u2022\u2022\u2022\u2022" // mask password override too (references aren't secret)
 		}
 
 		// When actively editing this row, replace override cell with text input.
@@ -1732,6 +1923,12 @@ func (m Model) renderEditOverlay() string {
 		b.WriteString(row + "\n")
 	}
 
+	pin := dimStyle.Render("assigned")
+	if e.PinnedPort != 0 {
+		pin = "pinned"
+	}
+	b.WriteString(fmt.Sprintf("\n  %-*s %d %s\n", fieldW, "Local port", e.LocalPort, pin))
+
 	// Help bar inside the popup.
 	b.WriteString("\n")
 	b.WriteString(m.renderEditBar())
@@ -2033,6 +2230,7 @@ func (m Model) renderEditBar() string {
 		helpKeyStyle.Render("\u2191\u2193") + helpBarStyle.Render(":select") + "  " +
 		helpKeyStyle.Render("Enter") + helpBarStyle.Render(":edit") + "  " +
 		helpKeyStyle.Render("d") + helpBarStyle.Render(":clear") + "  " +
+		helpKeyStyle.Render("p") + helpBarStyle.Render(":pin port") + "  " +
 		helpKeyStyle.Render("Esc") + helpBarStyle.Render(":done")
 }
 
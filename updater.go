@@ -522,3 +522,40 @@ func verifyChecksum(data []byte, expectedHex string) error {
 	}
 	return nil
 }
+
+// runUpdate implements `drillbit update [--check]`.
+func runUpdate(app *cliApp, args []string) int {
+	fs := app.flagSet("update")
+	checkOnly := fs.Bool("check", false, "only report whether an update is available")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if version == "dev" {
+		fmt.Fprintln(app.stderr, "Error: development builds cannot self-update")
+		return exitError
+	}
+
+	info, err := fetchLatestRelease()
+	if err != nil {
+		fmt.Fprintf(app.stderr, "Error: checking for updates: %v\n", err)
+		return exitError
+	}
+	if info == nil || !isNewer(info.Version, version) {
+		fmt.Fprintf(app.stdout, "drillbit %s is up to date\n", version)
+		return exitOK
+	}
+
+	if *checkOnly {
+		fmt.Fprintf(app.stdout, "drillbit v%s is available (current: %s)\n%s\n", info.Version, version, info.HTMLURL)
+		return exitOK
+	}
+
+	fmt.Fprintf(app.stderr, "Downloading drillbit v%s...\n", info.Version)
+	if err := doUpdate(*info); err != nil {
+		fmt.Fprintf(app.stderr, "Error: update failed: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(app.stdout, "Updated to v%s\n", info.Version)
+	return exitOK
+}
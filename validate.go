@@ -0,0 +1,505 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ConfigError is a single problem found in a config file, with the YAML
+// position it was found at.
+type ConfigError struct {
+	Path   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Msg)
+}
+
+// ConfigErrors is every problem found in a config file, in file order.
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type fieldKind int
+
+const (
+	fieldString fieldKind = iota
+	fieldBool
+	fieldList
+	fieldDuration  // Go duration string such as "45s", greater than zero
+	fieldPort      // TCP port number, 1-65535
+	fieldPortRange // "first-last" port range such as 10000-65535
+	fieldMap       // mapping with free-form keys
+	fieldVersion   // config schema version; checked by checkVersion
+)
+
+// fieldSpec is one key allowed in a config mapping.
+type fieldSpec struct {
+	name string
+	kind fieldKind
+}
+
+// Allowed keys per mapping, mirroring the yaml tags on Config, HostConfig,
+// HostImport, DatabaseOverride and DiscoveryRule.
+var (
+	configFields = []fieldSpec{
+		{"version", fieldVersion},
+		{"include", fieldList},
+		{"import_hosts", fieldList},
+		{"hosts", fieldList},
+		{"backup_dir", fieldString},
+		{"port_range", fieldPortRange},
+		{"env_port_ranges", fieldMap},
+		{"discovery", fieldList},
+	}
+	hostFields = []fieldSpec{
+		{"name", fieldString},
+		{"user", fieldString},
+		{"env", fieldString},
+		{"docker", fieldString},
+		{"docker_socket", fieldString},
+		{"sudo", fieldString},
+		{"command_timeout", fieldDuration},
+		{"discovery_timeout", fieldDuration},
+		{"keepalive", fieldDuration},
+		{"databases", fieldList},
+		{"discovery", fieldList},
+	}
+	importFields = []fieldSpec{
+		{"from_ssh_config", fieldString},
+		{"from_docker_context", fieldString},
+		{"user", fieldString},
+		{"env", fieldString},
+		{"env_pattern", fieldString},
+		{"docker", fieldString},
+		{"docker_socket", fieldString},
+		{"sudo", fieldString},
+		{"command_timeout", fieldDuration},
+		{"discovery_timeout", fieldDuration},
+		{"keepalive", fieldDuration},
+	}
+	databaseFields = []fieldSpec{
+		{"container", fieldString},
+		{"auto", fieldBool},
+		{"user", fieldString},
+		{"password", fieldString},
+		{"database", fieldString},
+		{"port", fieldPort},
+	}
+	discoveryFields = []fieldSpec{
+		{"type", fieldString},
+		{"images", fieldList},
+		{"include", fieldList},
+		{"exclude", fieldList},
+		{"user_env", fieldList},
+		{"password_env", fieldList},
+		{"database_env", fieldList},
+	}
+)
+
+// envLabelPattern matches env labels: anything on one line without tabs
+// or other control characters, which would break the table and the TSV
+// output of list.
+var envLabelPattern = regexp.MustCompile(`^[^\p{Cc}]+$`)
+
+// configValidator walks a parsed YAML document and collects problems.
+type configValidator struct {
+	path     string
+	fragment bool // included file: no include of its own
+	errs     ConfigErrors
+}
+
+func (v *configValidator) errorf(n *yaml.Node, format string, a ...any) {
+	v.errs = append(v.errs, ConfigError{Path: v.path, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, a...)})
+}
+
+// parseConfig strictly decodes config YAML, first upgrading files written
+// for an older schema version (see migrate.go). Unknown or mistyped fields,
+// duplicate hosts and containers, bad env labels and bad paths are all
+// reported together as ConfigErrors. fragment marks an included file.
+func parseConfig(path string, data []byte, fragment bool) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing YAML config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if len(root.Content) == 0 {
+		return &cfg, nil // empty file or only comments
+	}
+	doc := root.Content[0]
+	v := &configValidator{path: path, fragment: fragment}
+	if doc.Kind == yaml.MappingNode {
+		from := v.checkVersion(doc)
+		if len(v.errs) > 0 {
+			return nil, v.errs
+		}
+		if err := migrateConfig(doc, from); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	v.checkConfig(doc)
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	if err := root.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing YAML config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (v *configValidator) checkConfig(doc *yaml.Node) {
+	fields := v.checkMapping(doc, "config", configFields)
+	if fields == nil {
+		return
+	}
+
+	if inc := fields["include"]; inc != nil {
+		if v.fragment {
+			v.errorf(inc, "include is only allowed in the main config")
+		}
+		for _, n := range inc.Content {
+			if n.Kind != yaml.ScalarNode || n.Value == "" {
+				v.errorf(n, "include entries must be file paths or globs")
+			}
+		}
+	}
+
+	if imports := fields["import_hosts"]; imports != nil {
+		v.checkImports(imports)
+	}
+
+	if hosts := fields["hosts"]; hosts != nil {
+		v.checkHosts(hosts)
+	}
+
+	if n := fields["backup_dir"]; n != nil && n.Value != "" {
+		v.checkDir(n, "backup_dir")
+	}
+
+	if n := fields["env_port_ranges"]; n != nil {
+		v.checkEnvPortRanges(n)
+	}
+
+	if n := fields["discovery"]; n != nil {
+		v.checkDiscovery(n)
+	}
+}
+
+// checkEnvPortRanges checks that env_port_ranges maps env labels to port
+// ranges that don't overlap, so a port only ever belongs to one env.
+func (v *configValidator) checkEnvPortRanges(n *yaml.Node) {
+	type envRange struct {
+		env string
+		r   portRange
+	}
+	var seen []envRange
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, val := n.Content[i], n.Content[i+1]
+		if !envLabelPattern.MatchString(k.Value) {
+			v.errorf(k, "env label %q must not contain tabs, line breaks or other control characters", k.Value)
+			continue
+		}
+		r, err := parsePortRange(val.Value)
+		if val.Kind != yaml.ScalarNode || err != nil {
+			v.errorf(val, "port range for %s must be like 15000-15999", k.Value)
+			continue
+		}
+		for _, s := range seen {
+			if r.overlaps(s.r) {
+				v.errorf(val, "port range %s for %s overlaps %s for %s", r, k.Value, s.r, s.env)
+			}
+		}
+		seen = append(seen, envRange{k.Value, r})
+	}
+}
+
+func (v *configValidator) checkHosts(hosts *yaml.Node) {
+	seen := make(map[string]int) // host name -> line
+	for _, h := range hosts.Content {
+		fields := v.checkMapping(h, "host", hostFields)
+		if fields == nil {
+			continue
+		}
+
+		name := fields["name"]
+		switch {
+		case name == nil || name.Value == "":
+			v.errorf(h, "host is missing a name")
+		case strings.ContainsAny(name.Value, " \t/"):
+			v.errorf(name, "host name %q must not contain spaces or '/'", name.Value)
+		default:
+			if line, ok := seen[name.Value]; ok {
+				v.errorf(name, "duplicate host %q (first defined on line %d)", name.Value, line)
+			} else {
+				seen[name.Value] = name.Line
+			}
+		}
+
+		if env := fields["env"]; env != nil && env.Value != "" && !envLabelPattern.MatchString(env.Value) {
+			v.errorf(env, "env label %q must not contain tabs, line breaks or other control characters", env.Value)
+		}
+
+		v.checkRuntime(fields)
+
+		if dbs := fields["databases"]; dbs != nil {
+			v.checkDatabases(dbs)
+		}
+
+		if n := fields["discovery"]; n != nil {
+			v.checkDiscovery(n)
+		}
+	}
+}
+
+// checkRuntime checks the HostRuntime fields of a host or import rule.
+func (v *configValidator) checkRuntime(fields map[string]*yaml.Node) {
+	if d := fields["docker"]; d != nil && strings.TrimSpace(d.Value) == "" {
+		v.errorf(d, "docker must name a container CLI, e.g. docker or podman")
+	}
+	if s := fields["sudo"]; s != nil {
+		switch s.Value {
+		case sudoAuto, sudoAlways, sudoNever:
+		default:
+			v.errorf(s, "sudo must be auto, always or never")
+		}
+	}
+	if s := fields["docker_socket"]; s != nil {
+		switch {
+		case !strings.HasPrefix(s.Value, "/"):
+			v.errorf(s, "docker_socket must be an absolute path on the host, e.g. /var/run/docker.sock")
+		case fields["docker"] != nil:
+			v.errorf(s, "docker_socket replaces the docker CLI; set one of docker and docker_socket")
+		case fields["sudo"] != nil:
+			v.errorf(s, "sudo doesn't apply to docker_socket; the ssh user needs access to the socket")
+		}
+	}
+}
+
+func (v *configValidator) checkImports(imports *yaml.Node) {
+	for _, imp := range imports.Content {
+		fields := v.checkMapping(imp, "import", importFields)
+		if fields == nil {
+			continue
+		}
+
+		var source *yaml.Node
+		for _, key := range []string{"from_ssh_config", "from_docker_context"} {
+			n := fields[key]
+			if n == nil {
+				continue
+			}
+			if source != nil {
+				v.errorf(n, "import has both from_ssh_config and from_docker_context")
+				continue
+			}
+			source = n
+			if _, err := path.Match(n.Value, ""); err != nil || n.Value == "" {
+				v.errorf(n, "%s %q is not a valid glob", key, n.Value)
+			}
+		}
+		if source == nil {
+			v.errorf(imp, "import needs from_ssh_config or from_docker_context")
+		}
+
+		if env := fields["env"]; env != nil && env.Value != "" && !envLabelPattern.MatchString(env.Value) {
+			v.errorf(env, "env label %q must not contain tabs, line breaks or other control characters", env.Value)
+		}
+		if p := fields["env_pattern"]; p != nil {
+			if _, err := regexp.Compile(p.Value); err != nil {
+				v.errorf(p, "env_pattern: %v", err)
+			}
+		}
+		v.checkRuntime(fields)
+	}
+}
+
+func (v *configValidator) checkDatabases(dbs *yaml.Node) {
+	seen := make(map[string]int) // container -> line
+	for _, d := range dbs.Content {
+		fields := v.checkMapping(d, "database", databaseFields)
+		if fields == nil {
+			continue
+		}
+		c := fields["container"]
+		if c == nil || c.Value == "" {
+			v.errorf(d, "database is missing a container")
+			continue
+		}
+		if line, ok := seen[c.Value]; ok {
+			v.errorf(c, "duplicate container %q (first defined on line %d)", c.Value, line)
+		} else {
+			seen[c.Value] = c.Line
+		}
+	}
+}
+
+// checkDiscovery checks discovery rules: known badge types, patterns that
+// compile, and env var names. A rule must say which containers it is for.
+func (v *configValidator) checkDiscovery(rules *yaml.Node) {
+	for _, r := range rules.Content {
+		fields := v.checkMapping(r, "discovery rule", discoveryFields)
+		if fields == nil {
+			continue
+		}
+		if fields["images"] == nil && fields["include"] == nil {
+			v.errorf(r, "discovery rule needs images or include to say which containers it is for")
+		}
+		if t := fields["type"]; t != nil && t.Value != "" && !slices.Contains(imageTypes, t.Value) {
+			v.errorf(t, "type %q must be one of %s", t.Value, strings.Join(imageTypes, ", "))
+		}
+		for _, n := range listItems(fields["images"]) {
+			if n.Kind != yaml.ScalarNode {
+				v.errorf(n, "images entries must be regular expressions")
+			} else if _, err := regexp.Compile(n.Value); err != nil {
+				v.errorf(n, "images: %v", err)
+			}
+		}
+		for _, key := range []string{"include", "exclude"} {
+			for _, n := range listItems(fields[key]) {
+				if _, err := path.Match(n.Value, ""); err != nil || n.Kind != yaml.ScalarNode || n.Value == "" {
+					v.errorf(n, "%s entries must be container name globs: %q is not", key, n.Value)
+				}
+			}
+		}
+		for _, key := range []string{"user_env", "password_env", "database_env"} {
+			for _, n := range listItems(fields[key]) {
+				if n.Kind != yaml.ScalarNode || !envVarPattern.MatchString(n.Value) {
+					v.errorf(n, "%s entries must be environment variable names: %q is not", key, n.Value)
+				}
+			}
+		}
+	}
+}
+
+// listItems returns the items of a list node, nil for a missing one.
+func listItems(n *yaml.Node) []*yaml.Node {
+	if n == nil {
+		return nil
+	}
+	return n.Content
+}
+
+// checkDir reports a path that is relative, or that exists but is not
+// a directory.
+func (v *configValidator) checkDir(n *yaml.Node, key string) {
+	p := expandTildePath(n.Value)
+	if !filepath.IsAbs(p) {
+		v.errorf(n, "%s %q must be absolute or start with ~/", key, n.Value)
+		return
+	}
+	if info, err := os.Stat(p); err == nil && !info.IsDir() {
+		v.errorf(n, "%s %q is not a directory", key, n.Value)
+	}
+}
+
+// checkMapping verifies n is a mapping whose keys are all in fields with
+// values of the right kind. It returns the value node for each key, or
+// nil if n is not a mapping. Null values are allowed and omitted.
+func (v *configValidator) checkMapping(n *yaml.Node, what string, fields []fieldSpec) map[string]*yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "%s must be a mapping", what)
+		return nil
+	}
+
+	values := make(map[string]*yaml.Node)
+	keys := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, val := n.Content[i], n.Content[i+1]
+		if val.Kind == yaml.AliasNode {
+			val = val.Alias
+		}
+
+		spec, ok := findField(fields, k.Value)
+		if !ok {
+			v.errorf(k, "unknown field %q in %s (valid: %s)", k.Value, what, fieldNames(fields))
+			continue
+		}
+		if prev, dup := keys[k.Value]; dup {
+			v.errorf(k, "field %q already set on line %d", k.Value, prev.Line)
+			continue
+		}
+		keys[k.Value] = k
+
+		if val.Kind == yaml.ScalarNode && val.Tag == "!!null" {
+			continue
+		}
+		switch spec.kind {
+		case fieldString:
+			if val.Kind != yaml.ScalarNode {
+				v.errorf(val, "%s must be a string", k.Value)
+				continue
+			}
+		case fieldBool:
+			if val.Kind != yaml.ScalarNode || val.Tag != "!!bool" {
+				v.errorf(val, "%s must be true or false", k.Value)
+				continue
+			}
+		case fieldList:
+			if val.Kind != yaml.SequenceNode {
+				v.errorf(val, "%s must be a list", k.Value)
+				continue
+			}
+		case fieldDuration:
+			d, err := time.ParseDuration(val.Value)
+			if val.Kind != yaml.ScalarNode || err != nil || d <= 0 {
+				v.errorf(val, "%s must be a duration such as 45s or 2m", k.Value)
+				continue
+			}
+		case fieldPort:
+			p, err := strconv.Atoi(val.Value)
+			if val.Kind != yaml.ScalarNode || val.Tag != "!!int" || err != nil || p < 1 || p > 65535 {
+				v.errorf(val, "%s must be a port number between 1 and 65535", k.Value)
+				continue
+			}
+		case fieldMap:
+			if val.Kind != yaml.MappingNode {
+				v.errorf(val, "%s must be a mapping", k.Value)
+				continue
+			}
+		case fieldPortRange:
+			if _, err := parsePortRange(val.Value); val.Kind != yaml.ScalarNode || err != nil {
+				v.errorf(val, "%s must be a port range such as 10000-65535", k.Value)
+				continue
+			}
+		}
+		values[k.Value] = val
+	}
+	return values
+}
+
+func findField(fields []fieldSpec, name string) (fieldSpec, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return fieldSpec{}, false
+}
+
+func fieldNames(fields []fieldSpec) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
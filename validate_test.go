@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigValidation(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want []string // "line:col: substring", in order
+	}{
+		{
+			name: "unknown fields",
+			yaml: `hosts:
+  - name: server1
+    autoconnect: true
+    databases:
+      - container: db1
+        databse: app
+`,
+			want: []string{`3:5: unknown field "autoconnect" in host`, `6:9: unknown field "databse" in database`},
+		},
+		{
+			name: "duplicates",
+			yaml: `hosts:
+  - name: server1
+    databases:
+      - container: db1
+      - container: db1
+  - name: server1
+`,
+			want: []string{`5:20: duplicate container "db1" (first defined on line 4)`, `6:11: duplicate host "server1" (first defined on line 2)`},
+		},
+		{
+			name: "wrong types",
+			yaml: `hosts:
+  - name: server1
+    databases:
+      - container: db1
+        auto: yes please
+  - name: [a, b]
+`,
+			want: []string{"5:15: auto must be true or false", "6:11: name must be a string", "6:5: host is missing a name"},
+		},
+		{
+			name: "bad labels and names",
+			yaml: `hosts:
+  - name: deploy@server1
+    env: "prod\nwest"
+  - name: server2/x
+    databases:
+      - auto: true
+`,
+			want: []string{`3:10: env label "prod\nwest"`, `4:11: host name "server2/x" must not contain spaces or '/'`, "6:9: database is missing a container"},
+		},
+		{
+			name: "backup_dir",
+			yaml: "backup_dir: " + file + "\nhosts:\n  - name: server1\n",
+			want: []string{"1:13: backup_dir " + `"` + file + `" is not a directory`},
+		},
+		{
+			name: "relative backup_dir",
+			yaml: "backup_dir: backups\nhosts:\n  - name: server1\n",
+			want: []string{`1:13: backup_dir "backups" must be absolute`},
+		},
+		{
+			name: "runtime settings",
+			yaml: `hosts:
+  - name: server1
+    docker: ""
+    sudo: sometimes
+    command_timeout: 30
+    keepalive: -1s
+`,
+			want: []string{
+				"5:22: command_timeout must be a duration",
+				"6:16: keepalive must be a duration",
+				"3:13: docker must name a container CLI",
+				"4:11: sudo must be auto, always or never",
+			},
+		},
+		{
+			name: "docker_socket",
+			yaml: `hosts:
+  - name: server1
+    docker_socket: run/docker.sock
+  - name: server2
+    docker: podman
+    docker_socket: /run/podman/podman.sock
+  - name: server3
+    sudo: always
+    docker_socket: /var/run/docker.sock
+  - name: server4
+    docker_socket: /var/run/docker.sock
+`,
+			want: []string{
+				"3:20: docker_socket must be an absolute path",
+				"6:20: docker_socket replaces the docker CLI",
+				"9:20: sudo doesn't apply to docker_socket",
+			},
+		},
+		{
+			name: "pinned ports",
+			yaml: `hosts:
+  - name: server1
+    databases:
+      - container: a
+        port: 0
+      - container: b
+        port: "15432"
+      - container: c
+        port: 70000
+      - container: d
+        port: 15432
+`,
+			want: []string{
+				"5:15: port must be a port number",
+				"7:15: port must be a port number",
+				"9:15: port must be a port number",
+			},
+		},
+		{
+			name: "port range",
+			yaml: "port_range: 20000-10000\nhosts:\n  - name: server1\n",
+			want: []string{"1:13: port_range must be a port range such as 10000-65535"},
+		},
+		{
+			name: "env port ranges",
+			yaml: `env_port_ranges:
+  prod: 15000-15999
+  test: 15500-16000
+  "bad\tenv": 20000-20999
+  dev: lots
+hosts:
+  - name: server1
+`,
+			want: []string{
+				"3:9: port range 15500-16000 for test overlaps 15000-15999 for prod",
+				`4:3: env label "bad\tenv"`,
+				"5:8: port range for dev must be like 15000-15999",
+			},
+		},
+		{
+			name: "discovery rules",
+			yaml: `discovery:
+  - type: mysql
+    images: ["(postgres"]
+    password_env: [DB-PASS]
+  - user_env: [DB_USER]
+hosts:
+  - name: server1
+    discovery:
+      - include: ["[a"]
+`,
+			want: []string{
+				`9:19: include entries must be container name globs: "[a" is not`,
+				`2:11: type "mysql" must be one of postgres, postgis, timescale`,
+				"3:14: images: error parsing regexp",
+				`4:20: password_env entries must be environment variable names: "DB-PASS" is not`,
+				"5:5: discovery rule needs images or include",
+			},
+		},
+		{
+			name: "include in fragment",
+			yaml: "include: [other.yaml]\n",
+			want: []string{"1:10: include is only allowed in the main config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig("config.yaml", []byte(tt.yaml), tt.name == "include in fragment")
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected ConfigErrors, got %v", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(tt.want), errs)
+			}
+			for i, want := range tt.want {
+				if got := errs[i].Error(); !strings.HasPrefix(got, "config.yaml:") || !strings.Contains(got, want) {
+					t.Errorf("errs[%d] = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfigValid(t *testing.T) {
+	content := `backup_dir: ~/drillbit-backups
+hosts:
+  - name: server1
+    user: deploy
+    env: prod-eu_1
+    databases:
+      - container: db1
+        auto: true
+        user:
+  - name: server2
+    databases: []
+  - name: admin@server3
+    env: Production EU (primary)
+`
+	cfg, err := parseConfig("config.yaml", []byte(content), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Hosts) != 3 || !cfg.Hosts[0].Databases[0].Auto {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
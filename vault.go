@@ -0,0 +1,566 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+// vaultPassphraseEnv lets non-interactive runs (drillbit up under systemd,
+// scripts) unlock the vault without a prompt.
+const vaultPassphraseEnv = "DRILLBIT_VAULT_PASSPHRASE"
+
+var errWrongPassphrase = errors.New("wrong vault passphrase")
+
+// vaultFile is the on-disk vault: the secrets map as JSON, sealed with
+// AES-256-GCM under a key derived from the passphrase with argon2id.
+type vaultFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// vault is an unlocked credential vault. Secrets are keyed by
+// "host/container".
+type vault struct {
+	mu      sync.Mutex
+	path    string
+	hdr     vaultFile // KDF parameters and salt; nonce/data rewritten on save
+	key     []byte
+	secrets map[string]string
+}
+
+// vaultPath returns the vault location for a config file.
+func vaultPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "vault.json")
+}
+
+// vaultKey is the vault key, and vault: reference target, for an entry.
+func vaultKey(host, container string) string {
+	return host + "/" + container
+}
+
+func deriveVaultKey(passphrase []byte, hdr vaultFile) []byte {
+	return argon2.IDKey(passphrase, hdr.Salt, hdr.Time, hdr.Memory, hdr.Threads, 32)
+}
+
+// createVault writes a new, empty vault protected by passphrase.
+func createVault(path string, passphrase []byte) (*vault, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("vault %s already exists", path)
+	}
+	hdr := vaultFile{Version: 1, KDF: "argon2id", Time: 1, Memory: 64 * 1024, Threads: 4, Salt: make([]byte, 16)}
+	if _, err := rand.Read(hdr.Salt); err != nil {
+		return nil, err
+	}
+	v := &vault{path: path, hdr: hdr, key: deriveVaultKey(passphrase, hdr), secrets: make(map[string]string)}
+	err := withFileLock(path, func() error {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("vault %s already exists", path)
+		}
+		return v.save()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// openVault reads and decrypts the vault at path.
+func openVault(path string, passphrase []byte) (*vault, error) {
+	hdr, err := readVaultFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := deriveVaultKey(passphrase, hdr)
+	secrets, err := decryptVault(path, hdr, key)
+	if err != nil {
+		return nil, err
+	}
+	return &vault{path: path, hdr: hdr, key: key, secrets: secrets}, nil
+}
+
+// readVaultFile reads the sealed vault at path.
+func readVaultFile(path string) (vaultFile, error) {
+	var hdr vaultFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return hdr, err
+	}
+	if err := json.Unmarshal(data, &hdr); err != nil {
+		return hdr, fmt.Errorf("parsing vault %s: %w", path, err)
+	}
+	if hdr.Version != 1 || hdr.KDF != "argon2id" {
+		return hdr, fmt.Errorf("vault %s: unsupported format (version %d, kdf %q)", path, hdr.Version, hdr.KDF)
+	}
+	return hdr, nil
+}
+
+// decryptVault opens the secrets sealed in hdr with key.
+func decryptVault(path string, hdr vaultFile, key []byte) (map[string]string, error) {
+	gcm, err := newVaultCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, hdr.Nonce, hdr.Data, nil)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("vault %s: corrupt contents: %w", path, err)
+	}
+	if secrets == nil {
+		secrets = make(map[string]string)
+	}
+	return secrets, nil
+}
+
+func newVaultCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Get returns the secret stored under key.
+func (v *vault) Get(key string) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.secrets[key]
+	return s, ok
+}
+
+// Set stores a secret and writes the vault.
+func (v *vault) Set(key, secret string) error {
+	return v.update(func(secrets map[string]string) bool {
+		secrets[key] = secret
+		return true
+	})
+}
+
+// Delete removes a secret and writes the vault.
+func (v *vault) Delete(key string) error {
+	return v.update(func(secrets map[string]string) bool {
+		if _, ok := secrets[key]; !ok {
+			return false
+		}
+		delete(secrets, key)
+		return true
+	})
+}
+
+// update applies fn to the secrets and writes the vault if fn reports a
+// change. It holds the vault's file lock and first re-reads the file, so
+// entries another drillbit stored since this one unlocked are kept.
+func (v *vault) update(fn func(secrets map[string]string) bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return withFileLock(v.path, func() error {
+		if err := v.reload(); err != nil {
+			return err
+		}
+		if !fn(v.secrets) {
+			return nil
+		}
+		return v.save()
+	})
+}
+
+// reload replaces the secrets with those on disk. A vault that has been
+// deleted keeps the secrets in memory, and save writes them back. Caller
+// holds v.mu and the file lock.
+func (v *vault) reload() error {
+	hdr, err := readVaultFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	secrets, err := decryptVault(v.path, hdr, v.key)
+	if errors.Is(err, errWrongPassphrase) {
+		return fmt.Errorf("vault %s was recreated with another passphrase; restart drillbit to unlock it", v.path)
+	}
+	if err != nil {
+		return err
+	}
+	v.secrets = secrets
+	return nil
+}
+
+// Keys returns the stored keys in sorted order.
+func (v *vault) Keys() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.secrets))
+	for k := range v.secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// save seals the secrets under a fresh nonce and replaces the file
+// atomically. Caller holds v.mu and the file lock.
+func (v *vault) save() error {
+	plain, err := json.Marshal(v.secrets)
+	if err != nil {
+		return err
+	}
+	gcm, err := newVaultCipher(v.key)
+	if err != nil {
+		return err
+	}
+	hdr := v.hdr
+	hdr.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(hdr.Nonce); err != nil {
+		return err
+	}
+	hdr.Data = gcm.Seal(nil, hdr.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(hdr, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(v.path, data, 0o600)
+}
+
+// The vault unlocked for this process, used to resolve vault: references.
+var (
+	activeVaultMu sync.Mutex
+	activeVault   *vault
+)
+
+func setActiveVault(v *vault) {
+	activeVaultMu.Lock()
+	defer activeVaultMu.Unlock()
+	activeVault = v
+}
+
+func currentVault() *vault {
+	activeVaultMu.Lock()
+	defer activeVaultMu.Unlock()
+	return activeVault
+}
+
+// resolveVaultRef looks up a vault: reference in the unlocked vault.
+func resolveVaultRef(key string) (string, error) {
+	v := currentVault()
+	if v == nil {
+		return "", fmt.Errorf("vault is locked (set %s or run interactively)", vaultPassphraseEnv)
+	}
+	s, ok := v.Get(key)
+	if !ok {
+		return "", errors.New("not in the vault")
+	}
+	return s, nil
+}
+
+// storePassword moves a password override into the unlocked vault.
+// old is the override currently in the config. It returns what the config
+// should hold: a vault: reference for a literal password when a vault is
+// unlocked, value unchanged otherwise. Replacing or clearing a vault:
+// reference to this entry drops the stored secret. undo puts the entry
+// back the way it was, for when the config can't be saved; it is never
+// nil.
+func storePassword(host, container, old, value string) (stored string, undo func() error, err error) {
+	undo = func() error { return nil }
+	v := currentVault()
+	if v == nil {
+		return value, undo, nil
+	}
+	key := vaultKey(host, container)
+	ref := "vault:" + key
+	var prev string
+	var had bool
+	change := func(set bool) func(map[string]string) bool {
+		return func(secrets map[string]string) bool {
+			prev, had = secrets[key]
+			if !set {
+				delete(secrets, key)
+				return had
+			}
+			secrets[key] = literalPassword(value)
+			return true
+		}
+	}
+	switch {
+	case value != "" && !isSecretRef(value):
+		err = v.update(change(true))
+		stored = ref
+	case old == ref && value != ref:
+		err = v.update(change(false))
+		stored = value
+	default:
+		return value, undo, nil
+	}
+	if err != nil {
+		return "", undo, err
+	}
+	undo = func() error {
+		return v.update(func(secrets map[string]string) bool {
+			if had {
+				secrets[key] = prev
+			} else {
+				delete(secrets, key)
+			}
+			return true
+		})
+	}
+	return stored, undo, nil
+}
+
+// unlockVault opens the vault next to the config, if there is one, and
+// makes it the active vault. The passphrase comes from
+// DRILLBIT_VAULT_PASSPHRASE or a terminal prompt; an empty answer at the
+// prompt skips unlocking, leaving vault: references unresolved. Returns
+// false after reporting an error.
+func (app *cliApp) unlockVault() bool {
+	path := vaultPath(app.configPath)
+	if _, err := os.Stat(path); err != nil {
+		return true
+	}
+
+	if pass, ok := os.LookupEnv(vaultPassphraseEnv); ok {
+		v, err := openVault(path, []byte(pass))
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Error: opening vault: %v\n", err)
+			return false
+		}
+		setActiveVault(v)
+		return true
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return true
+	}
+
+	for range 3 {
+		pass, err := readPassphrase(app.stderr, "Vault passphrase (Enter to skip): ")
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Error: %v\n", err)
+			return false
+		}
+		if len(pass) == 0 {
+			fmt.Fprintln(app.stderr, "Vault locked; vault: passwords are unavailable this session.")
+			return true
+		}
+		v, err := openVault(path, pass)
+		if errors.Is(err, errWrongPassphrase) {
+			fmt.Fprintln(app.stderr, "Wrong passphrase.")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(app.stderr, "Error: opening vault: %v\n", err)
+			return false
+		}
+		setActiveVault(v)
+		return true
+	}
+	fmt.Fprintln(app.stderr, "Error: too many wrong passphrases")
+	return false
+}
+
+// readPassphrase prompts on w and reads a line from the terminal without
+// echoing it.
+func readPassphrase(w io.Writer, prompt string) ([]byte, error) {
+	fmt.Fprint(w, prompt)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(w)
+	return pass, err
+}
+
+// newVaultPassphrase gets the passphrase for a new vault: from
+// DRILLBIT_VAULT_PASSPHRASE, or entered twice at the terminal.
+func newVaultPassphrase(w io.Writer) ([]byte, error) {
+	if pass, ok := os.LookupEnv(vaultPassphraseEnv); ok {
+		if pass == "" {
+			return nil, fmt.Errorf("%s is empty", vaultPassphraseEnv)
+		}
+		return []byte(pass), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("no terminal to prompt for a passphrase (set %s)", vaultPassphraseEnv)
+	}
+	pass, err := readPassphrase(w, "New vault passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	again, err := readPassphrase(w, "Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if string(again) != string(pass) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// runVault implements `drillbit vault <init|migrate|list>`.
+func runVault(app *cliApp, args []string) int {
+	fs := app.flagSet("vault")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	sub := fs.Arg(0)
+	switch sub {
+	case "init", "migrate", "list":
+	case "":
+		return usageError(fs, "missing vault subcommand")
+	default:
+		return usageError(fs, "unknown vault subcommand %q", sub)
+	}
+	if fs.NArg() > 1 {
+		return usageError(fs, "unexpected argument %q", fs.Arg(1))
+	}
+
+	path := vaultPath(app.configPath)
+	_, statErr := os.Stat(path)
+	exists := statErr == nil
+
+	switch sub {
+	case "init":
+		if exists {
+			fmt.Fprintf(app.stderr, "Error: vault %s already exists\n", path)
+			return exitError
+		}
+		if _, err := initVault(app, path); err != nil {
+			fmt.Fprintf(app.stderr, "Error: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(app.stdout, "Created vault %s\n", path)
+		return exitOK
+
+	case "list":
+		if !exists {
+			fmt.Fprintf(app.stderr, "Error: no vault at %s (run 'drillbit vault init')\n", path)
+			return exitError
+		}
+		if !app.unlockVault() {
+			return exitError
+		}
+		v := currentVault()
+		if v == nil {
+			fmt.Fprintln(app.stderr, "Error: vault is locked")
+			return exitError
+		}
+		for _, k := range v.Keys() {
+			fmt.Fprintln(app.stdout, k)
+		}
+		return exitOK
+	}
+
+	// migrate
+	if !app.loadConfig() {
+		return exitError
+	}
+	if exists {
+		if !app.unlockVault() {
+			return exitError
+		}
+		if currentVault() == nil {
+			fmt.Fprintln(app.stderr, "Error: vault is locked")
+			return exitError
+		}
+	} else if _, err := initVault(app, path); err != nil {
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	moved, shared, undo, err := migratePasswords(app.cfg)
+	if err == nil && moved > 0 {
+		if err = SaveConfig(app.cfg, app.configPath); err != nil {
+			err = fmt.Errorf("saving config: %w", err)
+		}
+	}
+	if err != nil {
+		if undoErr := undo(); undoErr != nil {
+			err = fmt.Errorf("%w (and restoring the vault: %v)", err, undoErr)
+		}
+		fmt.Fprintf(app.stderr, "Error: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(app.stdout, "Moved %d password(s) into %s\n", moved, path)
+	if shared > 0 {
+		fmt.Fprintf(app.stderr, "Left %d password(s) from shared config fragments in place\n", shared)
+	}
+	return exitOK
+}
+
+// initVault creates the vault at path and makes it the active vault.
+func initVault(app *cliApp, path string) (*vault, error) {
+	pass, err := newVaultPassphrase(app.stderr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := createVault(path, pass)
+	if err != nil {
+		return nil, err
+	}
+	setActiveVault(v)
+	return v, nil
+}
+
+// migratePasswords moves every literal password override in the user's
+// own config into the active vault, replacing it with a vault: reference.
+// Passwords that come from shared fragments are counted but left alone,
+// since drillbit never rewrites those files. The caller saves cfg, and
+// calls undo to take the passwords back out of the vault if that fails.
+func migratePasswords(cfg *Config) (moved, shared int, undo func() error, err error) {
+	var undos []func() error
+	undo = func() error {
+		var errs []error
+		for i := len(undos) - 1; i >= 0; i-- {
+			errs = append(errs, undos[i]())
+		}
+		return errors.Join(errs...)
+	}
+	own := cfg.personalLayer()
+	for i := range cfg.Hosts {
+		h := &cfg.Hosts[i]
+		var oh HostConfig
+		if oi := hostIndex(own.Hosts, h.Name); oi >= 0 {
+			oh = own.Hosts[oi]
+		}
+		for j := range h.Databases {
+			db := &h.Databases[j]
+			if db.Password == "" || isSecretRef(db.Password) {
+				continue
+			}
+			if od := oh.GetOverride(db.Container); od == nil || od.Password == "" {
+				shared++
+				continue
+			}
+			ref, undoOne, err := storePassword(h.Name, db.Container, "", db.Password)
+			if err != nil {
+				return moved, shared, undo, err
+			}
+			undos = append(undos, undoOne)
+			db.Password = ref
+			moved++
+		}
+	}
+	return moved, shared, undo, nil
+}
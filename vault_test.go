@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	v, err := createVault(path, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set("server1/db1", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set("server1/db2", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete("server1/db2"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "s3cret") {
+		t.Error("vault file contains the plaintext secret")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("vault mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if _, err := openVault(path, []byte("wrong")); !errors.Is(err, errWrongPassphrase) {
+		t.Errorf("wrong passphrase: err = %v", err)
+	}
+	v2, err := openVault(path, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := v2.Get("server1/db1"); !ok || got != "s3cret" {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+	if keys := v2.Keys(); len(keys) != 1 {
+		t.Errorf("Keys = %v, want one key", keys)
+	}
+
+	if _, err := createVault(path, []byte("again")); err == nil {
+		t.Error("createVault over an existing vault should fail")
+	}
+}
+
+func TestResolveVaultRef(t *testing.T) {
+	t.Cleanup(func() { setActiveVault(nil) })
+	setActiveVault(nil)
+	if _, err := resolveSecret("vault:server1/db1", false); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("locked vault: err = %v", err)
+	}
+
+	v, err := createVault(filepath.Join(t.TempDir(), "vault.json"), []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Set("server1/db1", "s3cret")
+	setActiveVault(v)
+	if got, err := resolveSecret("vault:server1/db1", false); err != nil || got != "s3cret" {
+		t.Errorf("resolveSecret = %q, %v", got, err)
+	}
+	if _, err := resolveSecret("vault:server1/nope", false); err == nil {
+		t.Error("missing key should fail")
+	}
+}
+
+func TestStorePassword(t *testing.T) {
+	t.Cleanup(func() { setActiveVault(nil) })
+	setActiveVault(nil)
+	if got, _, _ := storePassword("h", "c", "", "plain"); got != "plain" {
+		t.Errorf("no vault: got %q, want the literal", got)
+	}
+
+	v, err := createVault(filepath.Join(t.TempDir(), "vault.json"), []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setActiveVault(v)
+
+	got, undo, err := storePassword("h", "c", "", "plain")
+	if err != nil || got != "vault:h/c" {
+		t.Fatalf("storePassword = %q, %v", got, err)
+	}
+	if s, _ := v.Get("h/c"); s != "plain" {
+		t.Errorf("vault holds %q", s)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.Get("h/c"); ok {
+		t.Error("undo left the new secret in the vault")
+	}
+	storePassword("h", "c", "", "plain")
+	got, undo, _ = storePassword("h", "c", "vault:h/c", "env:PW")
+	if got != "env:PW" {
+		t.Errorf("reference should pass through, got %q", got)
+	}
+	if _, ok := v.Get("h/c"); ok {
+		t.Error("replacing the vault reference should drop the secret")
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := v.Get("h/c"); s != "plain" {
+		t.Errorf("undo restored %q, want the dropped secret", s)
+	}
+}
+
+func TestVaultKeepsConcurrentEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	a, err := createVault(path, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := openVault(path, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Set("h/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set("h/b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := openVault(path, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(v.Keys(), ","); got != "h/a,h/b" {
+		t.Errorf("keys = %s, want both instances' entries", got)
+	}
+}
+
+func TestVaultMigrate(t *testing.T) {
+	t.Cleanup(func() { setActiveVault(nil) })
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`# my hosts
+hosts:
+  - name: server1
+    databases:
+      - container: db1
+        password: s3cret # old
+      - container: db2
+        password: env:PG_PW
+`), 0o600)
+	t.Setenv(vaultPassphraseEnv, "hunter2")
+
+	code, out, errOut := runCLITest("-c", path, "vault", "migrate")
+	if code != exitOK || !strings.Contains(out, "Moved 1 password(s)") {
+		t.Fatalf("code=%d out=%q stderr=%q", code, out, errOut)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("config still holds the plaintext password:\n%s", data)
+	}
+	for _, want := range []string{"# my hosts", "password: vault:server1/db1 # old", "password: env:PG_PW"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+
+	code, out, _ = runCLITest("-c", path, "vault", "list")
+	if code != exitOK || out != "server1/db1\n" {
+		t.Errorf("list: code=%d out=%q", code, out)
+	}
+
+	t.Setenv(vaultPassphraseEnv, "wrong")
+	if code, _, errOut := runCLITest("-c", path, "vault", "list"); code != exitError || !strings.Contains(errOut, "wrong vault passphrase") {
+		t.Errorf("wrong passphrase: code=%d stderr=%q", code, errOut)
+	}
+}
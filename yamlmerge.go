@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// identityKeys name the field that identifies an item in a sequence of
+// mappings (hosts by name, databases by container), so items can be
+// matched up when merging even if the list was reordered or grew.
+var identityKeys = []string{"name", "container"}
+
+// mergeYAML returns orig with v's values merged in, touching only the keys
+// whose values changed. Comments, key order, blank lines and the column of
+// trailing comments in orig are kept. If orig has no content, v is
+// marshaled from scratch below any comments; if it's not valid YAML, v is
+// marshaled from scratch.
+func mergeYAML(orig []byte, v any) ([]byte, error) {
+	var fresh yaml.Node
+	if err := fresh.Encode(v); err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	err := yaml.Unmarshal(orig, &root)
+	switch {
+	case err == nil && len(root.Content) == 0:
+		// Only comments: keep them above the new content.
+		out, err := encodeYAML(&fresh, 2)
+		if err != nil || len(bytes.TrimSpace(orig)) == 0 {
+			return out, err
+		}
+		return append(append(bytes.TrimRight(orig, "\n"), '\n'), out...), nil
+	case err != nil || root.Content[0].Kind != yaml.MappingNode:
+		return encodeYAML(&fresh, 2)
+	}
+	doc := root.Content[0]
+	mergeNode(doc, &fresh)
+
+	out, err := encodeYAML(&root, detectIndent(doc))
+	if err != nil {
+		return nil, err
+	}
+	return restoreLayout(orig, &root, out), nil
+}
+
+func encodeYAML(n *yaml.Node, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// detectIndent guesses the indent width from the first block sequence of
+// mappings ("key:\n  - a: b" is 2). Defaults to 2.
+func detectIndent(doc *yaml.Node) int {
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		k, v := doc.Content[i], doc.Content[i+1]
+		if v.Kind == yaml.SequenceNode && len(v.Content) > 0 && v.Content[0].Kind == yaml.MappingNode {
+			if n := v.Content[0].Column - k.Column - 2; n >= 2 && n <= 8 {
+				return n
+			}
+		}
+	}
+	return 2
+}
+
+// mergeNode updates dst in place to hold src's value. Nodes that already
+// match are left alone so their comments and style survive.
+func mergeNode(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		replaceNode(dst, src)
+		return
+	}
+	switch dst.Kind {
+	case yaml.ScalarNode:
+		if dst.Value != src.Value || dst.ShortTag() != src.ShortTag() {
+			replaceNode(dst, src)
+		}
+	case yaml.MappingNode:
+		mergeMapping(dst, src)
+	case yaml.SequenceNode:
+		mergeSequence(dst, src)
+	}
+}
+
+// replaceNode overwrites dst's value with src's, keeping dst's comments
+// and position.
+func replaceNode(dst, src *yaml.Node) {
+	head, lineComment, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+	line, col := dst.Line, dst.Column
+	*dst = *src
+	dst.HeadComment, dst.LineComment, dst.FootComment = head, lineComment, foot
+	dst.Line, dst.Column = line, col
+}
+
+func mergeMapping(dst, src *yaml.Node) {
+	want := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		want[src.Content[i].Value] = src.Content[i+1]
+	}
+
+	// Update or drop existing keys in place, then append new ones. A key
+	// missing from src was omitted as empty: keep it if it's already empty
+	// in dst (e.g. a hand-written "auto: false"), flip bools to false so
+	// the line stays, and drop anything else.
+	var content []*yaml.Node
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(dst.Content); i += 2 {
+		k, v := dst.Content[i], dst.Content[i+1]
+		seen[k.Value] = true
+		switch sv, ok := want[k.Value]; {
+		case ok && sameDuration(k.Value, v, sv):
+		case ok:
+			mergeNode(v, sv)
+		case isZeroNode(v):
+		case v.Kind == yaml.ScalarNode && v.ShortTag() == "!!bool":
+			v.Value = "false"
+		default:
+			continue
+		}
+		content = append(content, k, v)
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		if !seen[src.Content[i].Value] {
+			content = append(content, src.Content[i], src.Content[i+1])
+		}
+	}
+	dst.Content = content
+}
+
+// sameDuration reports whether dst and src are values of a duration key
+// (see hostFields) that parse to the same duration. A time.Duration
+// marshals as e.g. "2m0s", which mustn't replace a hand-written "2m".
+func sameDuration(key string, dst, src *yaml.Node) bool {
+	if !slices.Contains(hostFields, fieldSpec{key, fieldDuration}) ||
+		dst.Kind != yaml.ScalarNode || src.Kind != yaml.ScalarNode {
+		return false
+	}
+	a, err1 := time.ParseDuration(dst.Value)
+	b, err2 := time.ParseDuration(src.Value)
+	return err1 == nil && err2 == nil && a == b
+}
+
+func mergeSequence(dst, src *yaml.Node) {
+	var content []*yaml.Node
+	used := make(map[*yaml.Node]bool)
+	for i, s := range src.Content {
+		d := matchItem(dst, s, i)
+		if d == nil || used[d] {
+			content = append(content, s)
+			continue
+		}
+		used[d] = true
+		mergeNode(d, s)
+		content = append(content, d)
+	}
+	dst.Content = content
+}
+
+// matchItem finds the dst item corresponding to src item s at index i:
+// by identity key for mappings, by position otherwise.
+func matchItem(dst, s *yaml.Node, i int) *yaml.Node {
+	if s.Kind == yaml.MappingNode {
+		for _, key := range identityKeys {
+			id := mappingValue(s, key)
+			if id == nil {
+				continue
+			}
+			for _, d := range dst.Content {
+				if dv := mappingValue(d, key); dv != nil && dv.Value == id.Value {
+					return d
+				}
+			}
+			return nil
+		}
+	}
+	if i < len(dst.Content) {
+		return dst.Content[i]
+	}
+	return nil
+}
+
+// mappingValue returns the value node for key in a mapping, or nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// isZeroNode reports whether n decodes to its type's zero value.
+func isZeroNode(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			return true
+		case "!!bool":
+			return n.Value == "false"
+		case "!!str":
+			return n.Value == ""
+		}
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(n.Content) == 0
+	}
+	return false
+}
+
+// restoreLayout puts back what the yaml encoder drops: blank lines before
+// keys and list items, and padding before trailing comments. root is the
+// merged tree, whose original nodes still carry their line numbers in
+// orig; out is its encoding.
+func restoreLayout(orig []byte, root *yaml.Node, out []byte) []byte {
+	var outRoot yaml.Node
+	if err := yaml.Unmarshal(out, &outRoot); err != nil {
+		return out
+	}
+	origLines := strings.Split(string(orig), "\n")
+	outLines := strings.Split(string(out), "\n")
+	blankBefore := make(map[int]bool) // 0-based out line indexes
+
+	var walk func(n, o *yaml.Node)
+	walk = func(n, o *yaml.Node) {
+		if n.Kind != o.Kind || len(n.Content) != len(o.Content) {
+			return
+		}
+		if n.Line > 0 && n.Line <= len(origLines) && o.Line > 0 && o.Line <= len(outLines) {
+			if k := blankLineAbove(origLines, n.Line-1); k >= 0 {
+				blankBefore[o.Line-1-k] = true
+			}
+			if n.LineComment != "" {
+				alignComment(outLines, o.Line-1, n.LineComment, strings.LastIndex(origLines[n.Line-1], n.LineComment))
+			}
+		}
+		for i := range n.Content {
+			walk(n.Content[i], o.Content[i])
+		}
+	}
+	walk(root, &outRoot)
+
+	var b strings.Builder
+	for i, line := range outLines {
+		if blankBefore[i] && i > 0 && strings.TrimSpace(outLines[i-1]) != "" {
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+		if i < len(outLines)-1 {
+			b.WriteString("\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// blankLineAbove reports whether lines[idx] is preceded by a blank line,
+// possibly with comment lines in between. It returns the number of comment
+// lines between the two, or -1 if there is no blank line.
+func blankLineAbove(lines []string, idx int) int {
+	k := 0
+	for i := idx - 1; i >= 0; i-- {
+		t := strings.TrimSpace(lines[i])
+		switch {
+		case t == "":
+			return k
+		case strings.HasPrefix(t, "#"):
+			k++
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// alignComment pads lines[idx] so comment starts at column col, if the
+// line is short enough.
+func alignComment(lines []string, idx int, comment string, col int) {
+	if idx >= len(lines) || col < 0 {
+		return
+	}
+	line := lines[idx]
+	at := strings.LastIndex(line, comment)
+	if at < 0 || at >= col {
+		return
+	}
+	before := strings.TrimRight(line[:at], " ")
+	if len(before) >= col {
+		return
+	}
+	lines[idx] = before + strings.Repeat(" ", col-len(before)) + line[at:]
+}
+
+// writeFileAtomic writes data to a temp file in path's directory and
+// renames it into place, so readers never see a partial file. A symlink
+// at path is followed, so the file it points to is replaced and the link
+// stays, the way os.WriteFile would write through it.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op after a successful rename
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// diffLines returns the lines of a that differ from b, assuming both have
+// the same number of lines.
+func diffLines(a, b string) []string {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	var out []string
+	for i := range al {
+		if i >= len(bl) || al[i] != bl[i] {
+			out = append(out, al[i])
+		}
+	}
+	return out
+}
+
+func TestSaveConfigPreservesLayout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := ScaffoldConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := os.ReadFile(path)
+
+	save := func(edit func(cfg *Config)) string {
+		t.Helper()
+		if err := os.WriteFile(path, orig, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edit(cfg)
+		if err := SaveConfig(cfg, path); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		if got := save(func(*Config) {}); got != string(orig) {
+			t.Errorf("round trip changed the file:\n%s", got)
+		}
+	})
+
+	t.Run("toggle autoconnect", func(t *testing.T) {
+		got := save(func(cfg *Config) { cfg.Hosts[0].Databases[0].Auto = false })
+		diff := diffLines(got, string(orig))
+		if len(diff) != 1 || strings.TrimSpace(diff[0]) != "auto: false" {
+			t.Errorf("changed lines = %q\n%s", diff, got)
+		}
+	})
+
+	t.Run("password override", func(t *testing.T) {
+		got := save(func(cfg *Config) { cfg.Hosts[2].Databases[0].Password = "s3cret" })
+		want := strings.Replace(string(orig),
+			"      - container: testapp_db_1\n        auto: true\n",
+			"      - container: testapp_db_1\n        auto: true\n        password: s3cret\n", 1)
+		if got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("clear override", func(t *testing.T) {
+		got := save(func(cfg *Config) { cfg.Hosts[0].Databases[1].Password = "" })
+		if strings.Contains(got, "custom-override-password") {
+			t.Error("cleared password still present")
+		}
+		if !strings.Contains(got, "# environment label (optional)") || !strings.Contains(got, "# No databases configured") {
+			t.Errorf("comments lost:\n%s", got)
+		}
+	})
+
+	t.Run("new override", func(t *testing.T) {
+		got := save(func(cfg *Config) {
+			cfg.Hosts[2].Databases = append(cfg.Hosts[2].Databases, DatabaseOverride{Container: "other_db", Auto: true})
+		})
+		if !strings.HasSuffix(got, "      - container: other_db\n        auto: true\n") {
+			t.Errorf("new override not appended:\n%s", got)
+		}
+	})
+}
+
+func TestSaveConfigAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	cfg := &Config{Hosts: []HostConfig{{Name: "server1"}}}
+	for range 2 {
+		if err := SaveConfig(cfg, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// No temp files left behind; the lock file stays for the next save.
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 || files[0].Name() != "config.yaml" || files[1].Name() != "config.yaml.lock" {
+		t.Errorf("expected only config.yaml and its lock, got %v", files)
+	}
+}
+
+func TestSaveConfigThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("hosts:\n  - name: server1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Hosts: []HostConfig{{Name: "server2"}}}
+	if err := SaveConfig(cfg, link); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("config is no longer a symlink: %v, %v", fi, err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "server2") {
+		t.Errorf("link target not updated:\n%s", data)
+	}
+}